@@ -1 +1,109 @@
 package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const abaFeedURL = "https://platform.aklbadminton.com/api/booking/feed"
+
+var abaLocation = mustLoadLocation("Pacific/Auckland")
+
+// ABAVenue reads bookings from the Auckland Badminton Association booking feed.
+type ABAVenue struct {
+	VenueInfo
+	URL string
+	// BookingURL is the booking endpoint, blank if the bot can't book courts at the venue
+	BookingURL string
+	Client     *http.Client
+}
+
+// NewABAVenue returns the ABA Stadium with its 12 courts open from 6:00 to 22:00, it's the default venue.
+func NewABAVenue() *ABAVenue {
+	return &ABAVenue{
+		VenueInfo: VenueInfo{
+			ID:     "aba",
+			Name:   "ABA Stadium",
+			Courts: courtRange(12),
+			Open:   Clock{Hour: 6},
+			Close:  Clock{Hour: 22},
+			// the feed returns times with +13:00 or +12:00 offsets depending on DST
+			Location: abaLocation,
+		},
+		URL:    abaFeedURL,
+		Client: http.DefaultClient,
+	}
+}
+
+func (v *ABAVenue) Info() VenueInfo {
+	return v.VenueInfo
+}
+
+func (v *ABAVenue) Bookings(start, end time.Time) ([]Booking, error) {
+	const layout = "2006-01-02"
+	url := fmt.Sprintf("%s?start=%s&end=%s", v.URL, start.Format(layout), end.Format(layout))
+	log.Println("fetching", url)
+	resp, err := v.Client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected error code %d", resp.StatusCode)
+	}
+	var data []Booking
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("fetched %d bookings", len(data))
+	return data, nil
+}
+
+// CanBook reports whether BookingURL is configured.
+func (v *ABAVenue) CanBook() bool {
+	return v.BookingURL != ""
+}
+
+// Book posts the booking in the feed format to BookingURL with basic auth, the response is {"id": "<reference>"}.
+func (v *ABAVenue) Book(creds Credentials, booking Booking) (string, error) {
+	if !v.CanBook() {
+		return "", errors.New("booking isn't configured")
+	}
+	body, err := json.Marshal(booking)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, v.BookingURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(creds.Username, creds.Password)
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return "", fmt.Errorf("unexpected error code %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	var data struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return "", err
+	}
+	if data.ID == "" {
+		return "", errors.New("booking reference is missing")
+	}
+	return data.ID, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// parseAdminIDs parses a comma separated list of Telegram user IDs of bot admins.
+func parseAdminIDs(input string) (map[int64]bool, error) {
+	result := make(map[int64]bool)
+	for _, field := range strings.Split(input, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect admin ID: \"%s\"", field)
+		}
+		result[id] = true
+	}
+	return result, nil
+}
+
+// botAdminOnly ignores updates from users who aren't bot admins, so admin commands aren't discoverable.
+func botAdminOnly(ids map[int64]bool) tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			if c.Sender() == nil || !ids[c.Sender().ID] {
+				return nil
+			}
+			return next(c)
+		}
+	}
+}
+
+// ignoreBanned drops updates from banned users and groups.
+func ignoreBanned(store *Store) tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			if c.Sender() != nil && store.Banned(strconv.FormatInt(c.Sender().ID, 10)) {
+				return nil
+			}
+			if c.Chat() != nil && store.Banned(chatID(c)) {
+				return nil
+			}
+			return next(c)
+		}
+	}
+}
+
+func (s Stats) String() string {
+	lastFetch := "never"
+	if !s.LastFetch.IsZero() {
+		lastFetch = s.LastFetch.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("Users: %d\nGroups: %d\nSubscriptions: %d\nPaused: %d\nBanned: %d\nNotifications sent since start: %d\nLast fetch: %s",
+		s.Users, s.Groups, s.Subscriptions, s.Paused, s.Banned, s.Sent, lastFetch)
+}
+
+// usersList returns a line for every user and group sorted by ID.
+func usersList(users map[string]*UserData, now time.Time) string {
+	ids := make([]string, 0, len(users))
+	for id := range users {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var lines []string
+	for _, id := range ids {
+		user := users[id]
+		line := fmt.Sprintf("%s: %d subscriptions", id, len(user.Subscriptions))
+		if user.IsPaused(now) {
+			line += ", paused"
+		}
+		if user.Banned {
+			line += ", banned"
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return "No users"
+	}
+	return strings.Join(lines, "\n")
+}
+
+// handleAdmin registers commands of bot admins.
+func handleAdmin(g *tele.Group, store *Store) {
+	g.Handle("/stats", func(c tele.Context) error {
+		return c.Send(store.Stats().String())
+	})
+
+	g.Handle("/users", func(c tele.Context) error {
+		for _, msg := range splitMessage(store.UsersList(time.Now()), MessageLimit) {
+			if err := c.Send(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	g.Handle("/broadcast", func(c tele.Context) error {
+		text := strings.TrimSpace(c.Data())
+		if text == "" {
+			return c.Send("Expected format: /broadcast <text>")
+		}
+		queued, failed := store.Broadcast(text)
+		return c.Send(fmt.Sprintf("Queued to %d chats, failed %d", queued, failed))
+	})
+
+	ban := func(banned bool) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			id := strings.TrimSpace(c.Data())
+			if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+				return c.Send("Expected a user or group ID, see /users")
+			}
+			if err := store.Ban(id, banned); err != nil {
+				return c.Send(err.Error())
+			}
+			if banned {
+				return c.Send(fmt.Sprintf("%s is banned", id))
+			}
+			return c.Send(fmt.Sprintf("%s is unbanned", id))
+		}
+	}
+	g.Handle("/ban", ban(true))
+	g.Handle("/unban", ban(false))
+}
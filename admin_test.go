@@ -0,0 +1,109 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestParseAdminIDs(t *testing.T) {
+	ids, err := parseAdminIDs(" 1, 2,")
+	require.NoError(t, err)
+	assert.Equal(t, map[int64]bool{1: true, 2: true}, ids)
+
+	ids, err = parseAdminIDs("")
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	_, err = parseAdminIDs("1,x")
+	assert.Error(t, err)
+}
+
+func TestStore_Stats(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	require.NoError(t, store.Subscribe("1", "Mon 18:00"))
+	require.NoError(t, store.Subscribe("1", "Tue 18:00"))
+	require.NoError(t, store.Subscribe("-2", "Mon 18:00"))
+	require.NoError(t, store.Pause("-2", ""))
+	require.NoError(t, store.SetTimeZone("3", "UTC"))
+	require.NoError(t, store.Ban("3", true))
+	// unknown users aren't created by bans
+	assert.EqualError(t, store.Ban("4", true), "unknown user or group 4, see /users")
+
+	assert.True(t, store.Banned("3"))
+	assert.False(t, store.Banned("1"))
+	assert.False(t, store.Banned("4"))
+	assert.Equal(t, Stats{Users: 2, Groups: 1, Subscriptions: 3, Paused: 1, Banned: 1}, store.Stats())
+	assert.Contains(t, store.Stats().String(), "Last fetch: never")
+
+	assert.Equal(t, "-2: 1 subscriptions, paused\n1: 2 subscriptions\n3: 0 subscriptions, banned", store.UsersList(time.Now()))
+
+	require.NoError(t, store.Ban("3", false))
+	assert.False(t, store.Banned("3"))
+}
+
+func TestStore_Broadcast(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	telegram, email := &fakeNotifier{}, &fakeNotifier{}
+	store.Notifiers = map[string]Notifier{ChannelTelegram: telegram, ChannelEmail: email}
+	require.NoError(t, store.Subscribe("1", "Mon 18:00"))
+	require.NoError(t, store.SetChannels("1", map[string]string{ChannelEmail: "bob@example.com"}))
+	require.NoError(t, store.Subscribe("-2", "Mon 18:00"))
+	require.NoError(t, store.Subscribe("3", "Mon 18:00"))
+	require.NoError(t, store.Ban("3", true))
+
+	ok, failed := store.Broadcast("downtime <1h>")
+	assert.Equal(t, 2, ok)
+	assert.Equal(t, 0, failed)
+	assert.Equal(t, []string{"-2: downtime &lt;1h&gt;"}, telegram.texts)
+	assert.Equal(t, []string{"bob@example.com: downtime &lt;1h&gt;"}, email.texts)
+
+	// chats with channels which aren't available fail
+	delete(store.Notifiers, ChannelEmail)
+	ok, failed = store.Broadcast("downtime")
+	assert.Equal(t, 1, ok)
+	assert.Equal(t, 1, failed)
+}
+
+func TestHandleAdmin(t *testing.T) {
+	b, sent := testBot(t)
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	b.Use(ignoreBanned(store))
+	admins := b.Group()
+	admins.Use(botAdminOnly(map[int64]bool{1: true}))
+	handleAdmin(admins, store)
+	require.NoError(t, store.Subscribe("1", "Mon 18:00"))
+	require.NoError(t, store.Subscribe("3", "Mon 18:00"))
+
+	command := func(userID int64, text string) {
+		b.ProcessUpdate(tele.Update{Message: &tele.Message{
+			Sender: &tele.User{ID: userID},
+			Chat:   &tele.Chat{ID: userID, Type: tele.ChatPrivate},
+			Text:   text,
+		}})
+	}
+	// commands of other users are ignored
+	command(2, "/ban 3")
+	assert.False(t, store.Banned("3"))
+	assert.Empty(t, sent["sendMessage"])
+
+	command(1, "/ban x")
+	command(1, "/ban 4")
+	command(1, "/ban 3")
+	assert.True(t, store.Banned("3"))
+	assert.Equal(t, []string{"Expected a user or group ID, see /users", "unknown user or group 4, see /users", "3 is banned"}, sent["sendMessage"])
+	_, ok := store.User("4")
+	assert.False(t, ok)
+
+	// even admins are ignored when banned
+	command(1, "/ban 1")
+	command(1, "/unban 1")
+	assert.True(t, store.Banned("1"))
+}
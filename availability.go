@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// availabilityDay is the callback unique of Prev/Next buttons of /all.
+const availabilityDay = "all_day"
+
+// MessageLimit is the maximal length of a Telegram message.
+const MessageLimit = 4096
+
+// days returns midnights of the days in the calendar.
+func (cal Calendar) days() []time.Time {
+	var result []time.Time
+	for _, e := range cal.toSlice() {
+		day := today(e.Time)
+		if n := len(result); n == 0 || !result[n-1].Equal(day) {
+			result = append(result, day)
+		}
+	}
+	return result
+}
+
+// availabilityPage returns HTML text with free courts on the page-th day of the calendar and Prev/Next buttons.
+func availabilityPage(venue VenueInfo, cal Calendar, page int) (string, *tele.ReplyMarkup) {
+	days := cal.days()
+	if len(days) == 0 {
+		return fmt.Sprintf("No data for %s", html.EscapeString(venue.Name)), nil
+	}
+	if page < 0 {
+		page = 0
+	}
+	if page >= len(days) {
+		page = len(days) - 1
+	}
+	day := days[page]
+	dayCal := make(Calendar)
+	for t, courts := range cal {
+		if today(t).Equal(day) {
+			dayCal[t] = courts
+		}
+	}
+
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("<b>%s</b> at %s\n", day.Format("Mon 2 Jan"), html.EscapeString(venue.Name)))
+	ranges := dayCal.ranges()
+	if len(ranges) == 0 {
+		buf.WriteString("No free courts\n")
+	}
+	for _, r := range ranges {
+		buf.WriteString(fmt.Sprintf("<code>%s-%s</code> courts %s\n", r.Start.Format("15:04"), r.End.Format("15:04"), r.Courts))
+	}
+
+	m := &tele.ReplyMarkup{}
+	var buttons []tele.Btn
+	if page > 0 {
+		buttons = append(buttons, m.Data("◀ "+days[page-1].Format("Mon"), availabilityDay, venue.ID, strconv.Itoa(page-1)))
+	}
+	if page < len(days)-1 {
+		buttons = append(buttons, m.Data(days[page+1].Format("Mon")+" ▶", availabilityDay, venue.ID, strconv.Itoa(page+1)))
+	}
+	if len(buttons) == 0 {
+		return buf.String(), nil
+	}
+	m.Inline(m.Row(buttons...))
+	return buf.String(), m
+}
+
+// handleAvailability registers callbacks of /all buttons.
+func handleAvailability(b *tele.Bot, store *Store) {
+	b.Handle(&tele.Btn{Unique: availabilityDay}, func(c tele.Context) error {
+		args := strings.Split(c.Data(), "|")
+		if len(args) != 2 {
+			return fmt.Errorf("incorrect callback data: %q", c.Data())
+		}
+		page, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("incorrect callback data: %q", c.Data())
+		}
+		venue, ok := Venues.Get(args[0])
+		if !ok {
+			return c.Respond(&tele.CallbackResponse{Text: "Unknown venue"})
+		}
+		cal, err := fetchCalendar(venue, time.Now())
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: err.Error()})
+		}
+		if err := c.Respond(); err != nil {
+			return err
+		}
+		id := chatID(c)
+		text, markup := availabilityPage(venue.Info(), cal.In(store.Location(id)), page)
+		return c.Edit(text, markup, tele.ModeHTML)
+	})
+}
+
+// splitMessage splits text into messages no longer than limit, lines are kept whole unless a line is longer than limit.
+func splitMessage(text string, limit int) []string {
+	var result []string
+	var buf strings.Builder
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if buf.Len()+len(line) > limit && buf.Len() > 0 {
+			result = append(result, buf.String())
+			buf.Reset()
+		}
+		for len(line) > limit {
+			result = append(result, line[:limit])
+			line = line[limit:]
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		result = append(result, buf.String())
+	}
+	return result
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAvailabilityPage(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2022, 2, day, hour, minute, 0, 0, abaLocation)
+	}
+	cal := Calendar{
+		at(14, 6, 0):   {},
+		at(15, 18, 0):  {2, 5},
+		at(15, 18, 30): {2, 5},
+		at(15, 19, 0):  {1},
+		at(16, 6, 0):   {},
+	}
+	venue := VenueInfo{ID: "aba", Name: "A&B"}
+
+	text, markup := availabilityPage(venue, cal, 0)
+	assert.Equal(t, "<b>Mon 14 Feb</b> at A&amp;B\nNo free courts\n", text)
+	assert.Len(t, markup.InlineKeyboard[0], 1)
+	unique, data := press(t, markup, "Tue ▶")
+	assert.Equal(t, availabilityDay, unique)
+	assert.Equal(t, "aba|1", data)
+
+	text, markup = availabilityPage(venue, cal, 1)
+	assert.Equal(t, "<b>Tue 15 Feb</b> at A&amp;B\n<code>18:00-19:00</code> courts 2, 5\n<code>19:00-19:30</code> courts 1\n", text)
+	_, data = press(t, markup, "◀ Mon")
+	assert.Equal(t, "aba|0", data)
+	_, data = press(t, markup, "Wed ▶")
+	assert.Equal(t, "aba|2", data)
+
+	// out of range pages show the last day
+	text, markup = availabilityPage(venue, cal, 5)
+	assert.True(t, strings.HasPrefix(text, "<b>Wed 16 Feb</b>"), text)
+	assert.Len(t, markup.InlineKeyboard[0], 1)
+
+	text, markup = availabilityPage(venue, Calendar{}, 0)
+	assert.Equal(t, "No data for A&amp;B", text)
+	assert.Nil(t, markup)
+}
+
+func TestSplitMessage(t *testing.T) {
+	assert.Empty(t, splitMessage("", 10))
+	assert.Equal(t, []string{"abc\ndef\n"}, splitMessage("abc\ndef\n", 10))
+	assert.Equal(t, []string{"abc\n", "defgh\n", "ij"}, splitMessage("abc\ndefgh\nij", 7))
+	assert.Equal(t, []string{"ab\n", "cdefg", "hij\nk"}, splitMessage("ab\ncdefghij\nk", 5))
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Booker is implemented by venues which can book courts on behalf of users.
+type Booker interface {
+	// CanBook reports whether booking is configured for the venue.
+	CanBook() bool
+	// Book books the court and returns the booking reference.
+	Book(creds Credentials, booking Booking) (string, error)
+}
+
+// Credentials are the login of a user at a venue, they're saved encrypted.
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// AutoBooking is a booking made on behalf of the user.
+type AutoBooking struct {
+	Subscription string    `json:"subscription"`
+	Venue        string    `json:"venue"`
+	Court        int       `json:"court"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Reference    string    `json:"reference"`
+}
+
+// venueBooker returns the booker of the venue if the venue can book courts.
+func venueBooker(id string) (Booker, bool) {
+	v, ok := Venues.Get(id)
+	if !ok {
+		return nil, false
+	}
+	booker, ok := v.(Booker)
+	if !ok || !booker.CanBook() {
+		return nil, false
+	}
+	return booker, true
+}
+
+// assignCourts splits the block into bookings of the number of courts, a court is kept while it's free.
+// New courts are the ones which stay free the longest to avoid switching courts.
+func (cal Calendar) assignCourts(courts int) ([]Booking, bool) {
+	slots := cal.toSlice()
+	freeFor := func(i, court int) int {
+		n := 0
+		for ; i < len(slots) && slots[i].Courts.has(court); i++ {
+			n++
+		}
+		return n
+	}
+	var result []Booking
+	current := make([]int, courts)
+	open := make([]int, courts)
+	for n, e := range slots {
+		taken := make(map[int]bool)
+		next := make([]int, courts)
+		for i, court := range current {
+			if court != 0 && e.Courts.has(court) {
+				next[i] = court
+				taken[court] = true
+			}
+		}
+		for i := range next {
+			if next[i] != 0 {
+				continue
+			}
+			for _, court := range e.Courts {
+				if !taken[court] && (next[i] == 0 || freeFor(n, court) > freeFor(n, next[i])) {
+					next[i] = court
+				}
+			}
+			if next[i] == 0 {
+				return nil, false
+			}
+			taken[next[i]] = true
+		}
+		for i, court := range next {
+			if court == current[i] {
+				result[open[i]].End = e.Time.Add(SlotDuration)
+				continue
+			}
+			result = append(result, Booking{Court: court, Start: e.Time, End: e.Time.Add(SlotDuration)})
+			open[i] = len(result) - 1
+		}
+		current = next
+	}
+	return result, true
+}
+
+// pruneAutoBookings forgets bookings which have ended.
+func (u *UserData) pruneAutoBookings(now time.Time) {
+	kept := u.AutoBookings[:0]
+	for _, b := range u.AutoBookings {
+		if b.End.After(now) {
+			kept = append(kept, b)
+		}
+	}
+	u.AutoBookings = kept
+}
+
+// plannedBookings returns courts to book for new blocks of auto-booking subscriptions to the venue,
+// at most one block a day for each subscription. Blocks with slots the user was notified about over any channel are skipped,
+// so failed bookings aren't retried. References of the returned bookings are blank.
+func plannedBookings(user *UserData, venue VenueInfo, cal Calendar) [][]AutoBooking {
+	var result [][]AutoBooking
+	bookedOn := func(sub string, day time.Time) bool {
+		for _, b := range user.AutoBookings {
+			if b.Subscription == sub && today(b.Start.In(venue.Location)).Equal(day) {
+				return true
+			}
+		}
+		for _, block := range result {
+			if block[0].Subscription == sub && today(block[0].Start.In(venue.Location)).Equal(day) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, sub := range user.Subscriptions {
+		if !sub.AutoBook || sub.Paused || sub.VenueID() != venue.ID {
+			continue
+		}
+	blocks:
+		for _, block := range cal.blocks(sub) {
+			if bookedOn(sub.ID, today(block.toSlice()[0].Time)) {
+				continue
+			}
+			for t := range block {
+				if user.notifiedOverAny(Slot{Venue: venue.ID, Time: t}) {
+					continue blocks
+				}
+			}
+			bookings, ok := block.assignCourts(int(sub.MinCourts()))
+			if !ok {
+				continue
+			}
+			planned := make([]AutoBooking, 0, len(bookings))
+			for _, b := range bookings {
+				planned = append(planned, AutoBooking{Subscription: sub.ID, Venue: venue.ID, Court: b.Court, Start: b.Start, End: b.End})
+			}
+			result = append(result, planned)
+		}
+	}
+	return result
+}
+
+// autoBook books courts for auto-booking subscriptions of the user and returns lines reporting booked and failed courts.
+// Courts are booked without holding the store lock, every booking is saved as soon as it's made.
+// Booking of a block stops at the first failure, slots of a block are marked as notified only when all its courts are booked,
+// so partly booked blocks are announced as available.
+func (s *Store) autoBook(userID string, venue VenueInfo, cal Calendar, now time.Time) []string {
+	booker, ok := venueBooker(venue.ID)
+	if !ok {
+		return nil
+	}
+	user, ok := s.User(userID)
+	if !ok || user.Banned || user.IsPaused(now) {
+		return nil
+	}
+	creds, ok := s.credentials(user, venue.ID)
+	if !ok {
+		return nil
+	}
+	s.RLock()
+	for _, u := range s.unsavedBookings[userID] {
+		u.apply(user)
+	}
+	s.RUnlock()
+	describe := func(b AutoBooking) string {
+		return fmt.Sprintf("court %d %s-%s", b.Court, b.Start.In(user.Location()).Format("Mon 2 Jan 15:04"), b.End.In(user.Location()).Format("15:04"))
+	}
+	var lines []string
+	for _, block := range plannedBookings(user, venue, cal) {
+		for i, b := range block {
+			ref, err := booker.Book(creds, Booking{Court: b.Court, Start: b.Start, End: b.End})
+			if err != nil {
+				lines = append(lines, fmt.Sprintf("Could not book %s: %s", describe(b), err))
+				for _, rest := range block[i+1:] {
+					lines = append(lines, fmt.Sprintf("Did not book %s", describe(rest)))
+				}
+				break
+			}
+			b.Reference = ref
+			block[i] = b
+			booked := unsavedBooking{Booking: b}
+			if i == len(block)-1 {
+				booked.Notified = block
+			}
+			s.addAutoBooking(userID, booked)
+			lines = append(lines, fmt.Sprintf("Booked %s, reference %s", describe(b), ref))
+		}
+	}
+	return lines
+}
+
+// unsavedBooking is an auto-booking to save, Notified are bookings whose slots are marked as notified with it.
+type unsavedBooking struct {
+	Booking  AutoBooking
+	Notified []AutoBooking
+}
+
+func (u unsavedBooking) apply(user *UserData) {
+	user.AutoBookings = append(user.AutoBookings, u.Booking)
+	for _, b := range u.Notified {
+		for t := b.Start; t.Before(b.End); t = t.Add(SlotDuration) {
+			user.addToNotified(Slot{Venue: b.Venue, Time: t})
+		}
+	}
+}
+
+// addAutoBooking saves the booking, the court is booked even if saving fails,
+// so the booking is kept in unsaved bookings then and saved with the next check.
+func (s *Store) addAutoBooking(userID string, b unsavedBooking) {
+	err := s.Update(userID, func(user *UserData) error {
+		b.apply(user)
+		return nil
+	})
+	if err == nil {
+		return
+	}
+	log.Println("could not save booking", b.Booking.Reference, err)
+	s.Lock()
+	defer s.Unlock()
+	if s.unsavedBookings == nil {
+		s.unsavedBookings = make(map[string][]unsavedBooking)
+	}
+	s.unsavedBookings[userID] = append(s.unsavedBookings[userID], b)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeBooker struct {
+	bookings []Booking
+	// book is called before a booking is made, the booking fails if it returns an error
+	book func(b Booking) error
+}
+
+func (f *fakeBooker) CanBook() bool {
+	return true
+}
+
+func (f *fakeBooker) Book(creds Credentials, booking Booking) (string, error) {
+	if f.book != nil {
+		if err := f.book(booking); err != nil {
+			return "", err
+		}
+	}
+	f.bookings = append(f.bookings, booking)
+	return fmt.Sprintf("B%d", len(f.bookings)), nil
+}
+
+func TestABAVenue_Book(t *testing.T) {
+	var got Booking
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "user" || password != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.Write([]byte(`{"id": "B123"}`))
+	}))
+	defer server.Close()
+
+	venue := NewABAVenue()
+	assert.False(t, venue.CanBook())
+	venue.BookingURL = server.URL
+	assert.True(t, venue.CanBook())
+
+	start := time.Date(2022, 2, 14, 18, 0, 0, 0, abaLocation)
+	booking := Booking{Court: 3, Start: start, End: start.Add(time.Hour)}
+	ref, err := venue.Book(Credentials{Username: "user", Password: "secret"}, booking)
+	require.NoError(t, err)
+	assert.Equal(t, "B123", ref)
+	assert.Equal(t, 3, got.Court)
+	assert.True(t, got.Start.Equal(booking.Start))
+	assert.True(t, got.End.Equal(booking.End))
+
+	_, err = venue.Book(Credentials{Username: "user", Password: "wrong"}, booking)
+	assert.EqualError(t, err, "unexpected error code 401: unauthorized")
+}
+
+func TestCalendar_assignCourts(t *testing.T) {
+	start := time.Date(2022, 2, 14, 18, 0, 0, 0, abaLocation)
+	cal := Calendar{
+		start:                       {1, 2},
+		start.Add(SlotDuration):     {2, 3},
+		start.Add(2 * SlotDuration): {2, 3},
+	}
+	bookings, ok := cal.assignCourts(1)
+	require.True(t, ok)
+	assert.Equal(t, []Booking{{Court: 2, Start: start, End: start.Add(3 * SlotDuration)}}, bookings)
+
+	bookings, ok = cal.assignCourts(2)
+	require.True(t, ok)
+	assert.Equal(t, []Booking{
+		{Court: 2, Start: start, End: start.Add(3 * SlotDuration)},
+		{Court: 1, Start: start, End: start.Add(SlotDuration)},
+		{Court: 3, Start: start.Add(SlotDuration), End: start.Add(3 * SlotDuration)},
+	}, bookings)
+
+	_, ok = cal.assignCourts(3)
+	assert.False(t, ok)
+}
+
+func TestPlannedBookings(t *testing.T) {
+	venue := NewABAVenue().Info()
+	monday := time.Date(2022, 2, 14, 18, 0, 0, 0, abaLocation)
+	cal := Calendar{
+		monday:                   {1, 2},
+		monday.Add(SlotDuration): {1, 2},
+		monday.AddDate(0, 0, 7):  {1},
+		monday.AddDate(0, 0, 7).Add(SlotDuration): {1},
+	}
+	newUser := func() *UserData {
+		user := NewUserData("1")
+		user.Subscriptions = []Subscription{
+			{ID: "a", Days: NewWeekdays(time.Monday), Time: Clock{Hour: 18}, Minutes: 60, AutoBook: true},
+			{ID: "b", Days: NewWeekdays(time.Monday), Time: Clock{Hour: 18}, Minutes: 60},
+		}
+		return user
+	}
+
+	t.Run("once a day", func(t *testing.T) {
+		user := newUser()
+		assert.Equal(t, [][]AutoBooking{
+			{{Subscription: "a", Venue: "aba", Court: 1, Start: monday, End: monday.Add(time.Hour)}},
+			{{Subscription: "a", Venue: "aba", Court: 1, Start: monday.AddDate(0, 0, 7), End: monday.AddDate(0, 0, 7).Add(time.Hour)}},
+		}, plannedBookings(user, venue, cal))
+
+		user.AutoBookings = []AutoBooking{{Subscription: "a", Venue: "aba", Court: 2, Start: monday.Add(time.Hour), End: monday.Add(2 * time.Hour)}}
+		planned := plannedBookings(user, venue, cal)
+		require.Len(t, planned, 1)
+		assert.Equal(t, monday.AddDate(0, 0, 7), planned[0][0].Start)
+	})
+
+	t.Run("skips notified and paused", func(t *testing.T) {
+		user := newUser()
+		user.addToNotified(Slot{Venue: venue.ID, Time: monday})
+		assert.Len(t, plannedBookings(user, venue, cal), 1)
+
+		user = newUser()
+		user.Subscriptions[0].Paused = true
+		assert.Empty(t, plannedBookings(user, venue, cal))
+	})
+}
+
+func TestUserData_pruneAutoBookings(t *testing.T) {
+	monday := time.Date(2022, 2, 14, 18, 0, 0, 0, abaLocation)
+	user := NewUserData("1")
+	user.AutoBookings = []AutoBooking{
+		{Reference: "past", Start: monday, End: monday.Add(time.Hour)},
+		{Reference: "next", Start: monday.AddDate(0, 0, 7), End: monday.AddDate(0, 0, 7).Add(time.Hour)},
+	}
+	user.pruneAutoBookings(monday.Add(time.Hour))
+	require.Len(t, user.AutoBookings, 1)
+	assert.Equal(t, "next", user.AutoBookings[0].Reference)
+}
+
+// bookingVenue is a venue which books courts with the fake booker.
+type bookingVenue struct {
+	Venue
+	*fakeBooker
+}
+
+func TestStore_NotifyAll_AutoBook(t *testing.T) {
+	booker := &fakeBooker{}
+	withVenues(t, VenueList{bookingVenue{Venue: NewABAVenue(), fakeBooker: booker}})
+	dir := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, os.Mkdir(dir, 0700))
+	store, err := NewStore(filepath.Join(dir, "data.json"))
+	require.NoError(t, err)
+	store.Cipher, err = NewCipher(testKey)
+	require.NoError(t, err)
+	telegram := &fakeNotifier{}
+	store.Notifiers = map[string]Notifier{ChannelTelegram: telegram}
+	require.NoError(t, store.SetCredentials("1", "aba", Credentials{Username: "user", Password: "secret"}))
+	require.NoError(t, store.Subscribe("1", "Mon 18:00 x2 book"))
+	require.NoError(t, store.Subscribe("1", "Tue 18:00 x3 book"))
+
+	venue := Venues[0].Info()
+	now := time.Now().In(venue.Location)
+	monday := today(now).AddDate(0, 0, 7-(int(now.Weekday())+6)%7).Add(18 * time.Hour)
+	tuesday := monday.AddDate(0, 0, 1)
+	cal := Calendar{
+		monday: {1, 2}, monday.Add(SlotDuration): {1, 2},
+		tuesday: {1, 2, 3}, tuesday.Add(SlotDuration): {1, 2, 3},
+	}
+
+	booker.book = func(b Booking) error {
+		assert.True(t, store.TryLock(), "the store isn't locked while booking")
+		store.Unlock()
+		user, _ := store.User("1")
+		assert.Len(t, user.AutoBookings, len(booker.bookings), "bookings are saved right away")
+		if b.Start.Equal(tuesday) && b.Court == 2 {
+			return errors.New("no credit")
+		}
+		return nil
+	}
+	store.NotifyAll(venue, cal)
+	// booking of the block stops at the failed court
+	require.Len(t, booker.bookings, 3)
+	user, _ := store.User("1")
+	assert.Len(t, user.AutoBookings, 3)
+	require.Len(t, telegram.texts, 2)
+	assert.Contains(t, telegram.texts[0], "Booked court 1 "+tuesday.Format("Mon 2 Jan 15:04"))
+	assert.Contains(t, telegram.texts[0], "Could not book court 2 "+tuesday.Format("Mon 2 Jan 15:04"))
+	assert.Contains(t, telegram.texts[0], "Did not book court 3 "+tuesday.Format("Mon 2 Jan 15:04"))
+	// slots of the fully booked block aren't announced, the partly booked block is announced and isn't booked again
+	assert.Contains(t, telegram.texts[1], "New booking available")
+	assert.Contains(t, telegram.texts[1], tuesday.Format("2006-01-02"))
+	assert.NotContains(t, telegram.texts[1], monday.Format("2006-01-02"))
+	store.NotifyAll(venue, cal)
+	assert.Len(t, booker.bookings, 3)
+	assert.Len(t, telegram.texts, 2)
+
+	t.Run("failed save", func(t *testing.T) {
+		require.NoError(t, store.Subscribe("1", "Wed 18:00 book"))
+		wednesday := tuesday.AddDate(0, 0, 1)
+		cal := Calendar{wednesday: {1}, wednesday.Add(SlotDuration): {1}}
+		booker.book = func(b Booking) error {
+			return os.Rename(dir, dir+".moved")
+		}
+		store.NotifyAll(venue, cal)
+		require.Len(t, booker.bookings, 4)
+		// the unsaved booking isn't applied to the user, it isn't booked again
+		user, _ := store.User("1")
+		assert.Len(t, user.AutoBookings, 3)
+		store.NotifyAll(venue, cal)
+		assert.Len(t, booker.bookings, 4)
+		require.NoError(t, os.Rename(dir+".moved", dir))
+
+		// the booking is saved with the next check
+		store.NotifyAll(venue, cal)
+		assert.Len(t, booker.bookings, 4)
+		assert.Empty(t, store.unsavedBookings)
+		store, err := NewStore(filepath.Join(dir, "data.json"))
+		require.NoError(t, err)
+		user, _ = store.User("1")
+		assert.Len(t, user.AutoBookings, 4)
+	})
+}
+
+func TestStore_SetCredentials(t *testing.T) {
+	venue := NewABAVenue()
+	venue.BookingURL = "http://localhost"
+	withVenues(t, VenueList{venue})
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+
+	creds := Credentials{Username: "user", Password: "secret"}
+	assert.Error(t, store.SetCredentials("1", "aba", creds))
+
+	store.Cipher, err = NewCipher(testKey)
+	require.NoError(t, err)
+	assert.Error(t, store.SetCredentials("1", "north", creds))
+	require.NoError(t, store.SetCredentials("1", "aba", creds))
+
+	user, ok := store.User("1")
+	require.True(t, ok)
+	assert.NotContains(t, user.Credentials["aba"], "secret")
+	got, ok := store.credentials(user, "aba")
+	require.True(t, ok)
+	assert.Equal(t, creds, got)
+
+	require.NoError(t, store.DeleteCredentials("1", "aba"))
+	assert.Error(t, store.DeleteCredentials("1", "aba"))
+	user, _ = store.User("1")
+	_, ok = store.credentials(user, "aba")
+	assert.False(t, ok)
+}
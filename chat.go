@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strconv"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const adminsOnlyText = "Only chat admins can change subscriptions of the group"
+
+// chatID returns the store key of the chat, subscriptions in groups belong to the group.
+// In private chats the chat ID is the user ID.
+func chatID(c tele.Context) string {
+	return strconv.FormatInt(c.Chat().ID, 10)
+}
+
+// chatRecipient returns the chat of a store key, group IDs are negative.
+func chatRecipient(id string) (tele.Recipient, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &tele.Chat{ID: n}, nil
+}
+
+func isGroup(chat *tele.Chat) bool {
+	return chat.Type == tele.ChatGroup || chat.Type == tele.ChatSuperGroup
+}
+
+// chatAdmin reports whether the user is an admin of the chat using the Telegram API.
+func chatAdmin(b *tele.Bot) func(chat *tele.Chat, user *tele.User) (bool, error) {
+	return func(chat *tele.Chat, user *tele.User) (bool, error) {
+		member, err := b.ChatMemberOf(chat, user)
+		if err != nil {
+			return false, err
+		}
+		return member.Role == tele.Creator || member.Role == tele.Administrator, nil
+	}
+}
+
+// adminOnly lets only chat admins use handlers in groups, private chats aren't restricted.
+func adminOnly(isAdmin func(chat *tele.Chat, user *tele.User) (bool, error)) tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			chat := c.Chat()
+			if chat == nil || !isGroup(chat) {
+				return next(c)
+			}
+			// anonymous admins post on behalf of the group
+			if msg := c.Message(); msg != nil && c.Callback() == nil && msg.SenderChat != nil && msg.SenderChat.ID == chat.ID {
+				return next(c)
+			}
+			ok, err := isAdmin(chat, c.Sender())
+			if err != nil {
+				return err
+			}
+			if ok {
+				return next(c)
+			}
+			if c.Callback() != nil {
+				return c.Respond(&tele.CallbackResponse{Text: adminsOnlyText})
+			}
+			return c.Send(adminsOnlyText)
+		}
+	}
+}
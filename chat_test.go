@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+// testBot returns a bot talking to a fake Telegram API, sent texts are collected by method.
+func testBot(t *testing.T) (*tele.Bot, map[string][]string) {
+	sent := make(map[string][]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		method := path.Base(r.URL.Path)
+		text, _ := payload["text"].(string)
+		sent[method] = append(sent[method], text)
+		if method == "answerCallbackQuery" {
+			w.Write([]byte(`{"ok": true, "result": true}`))
+			return
+		}
+		w.Write([]byte(`{"ok": true, "result": {"message_id": 1, "chat": {"id": -1}}}`))
+	}))
+	t.Cleanup(server.Close)
+	b, err := tele.NewBot(tele.Settings{URL: server.URL, Offline: true, Synchronous: true})
+	require.NoError(t, err)
+	return b, sent
+}
+
+func TestChatRecipient(t *testing.T) {
+	to, err := chatRecipient("-1001")
+	require.NoError(t, err)
+	assert.Equal(t, "-1001", to.Recipient())
+
+	_, err = chatRecipient("x")
+	assert.Error(t, err)
+}
+
+func TestAdminOnly(t *testing.T) {
+	b, sent := testBot(t)
+	admin := &tele.User{ID: 1}
+	group := &tele.Chat{ID: -1, Type: tele.ChatGroup}
+	isAdmin := func(chat *tele.Chat, user *tele.User) (bool, error) {
+		return chat == group && user == admin, nil
+	}
+	var called int
+	h := adminOnly(isAdmin)(func(c tele.Context) error {
+		called++
+		return nil
+	})
+
+	message := func(chat *tele.Chat, sender *tele.User) tele.Context {
+		return b.NewContext(tele.Update{Message: &tele.Message{Chat: chat, Sender: sender}})
+	}
+	require.NoError(t, h(message(&tele.Chat{ID: 2, Type: tele.ChatPrivate}, &tele.User{ID: 2})))
+	require.NoError(t, h(message(group, admin)))
+	assert.Equal(t, 2, called)
+
+	require.NoError(t, h(message(group, &tele.User{ID: 2})))
+	assert.Equal(t, 2, called)
+	assert.Equal(t, []string{adminsOnlyText}, sent["sendMessage"])
+
+	callback := b.NewContext(tele.Update{Callback: &tele.Callback{
+		ID:      "1",
+		Sender:  &tele.User{ID: 2},
+		Message: &tele.Message{Chat: group},
+	}})
+	require.NoError(t, h(callback))
+	assert.Equal(t, 2, called)
+	assert.Equal(t, []string{adminsOnlyText}, sent["answerCallbackQuery"])
+
+	// anonymous admins send messages on behalf of the group
+	anonymous := b.NewContext(tele.Update{Message: &tele.Message{Chat: group, Sender: &tele.User{ID: 3}, SenderChat: group}})
+	require.NoError(t, h(anonymous))
+	assert.Equal(t, 3, called)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type emailMessage struct {
+	to   string
+	n    Notification
+	done func(sent *SentMessage, err error)
+}
+
+// EmailNotifier sends notifications as HTML emails over SMTP in a worker, the address is the email address.
+// Failed emails aren't retried, the slots stay unnotified over email and are sent again with the next check.
+type EmailNotifier struct {
+	// Addr is host:port of the SMTP server
+	Addr string
+	From string
+	// Auth is nil for servers without authentication
+	Auth     smtp.Auth
+	messages chan emailMessage
+	done     chan struct{}
+}
+
+func NewEmailNotifier(addr, from string, auth smtp.Auth, size int) *EmailNotifier {
+	return &EmailNotifier{
+		Addr:     addr,
+		From:     from,
+		Auth:     auth,
+		messages: make(chan emailMessage, size),
+		done:     make(chan struct{}),
+	}
+}
+
+func (e *EmailNotifier) Notify(address string, n Notification, done func(sent *SentMessage, err error)) error {
+	select {
+	case e.messages <- emailMessage{to: address, n: n, done: done}:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// Run sends queued emails until the notifier is closed.
+func (e *EmailNotifier) Run() {
+	defer close(e.done)
+	for m := range e.messages {
+		err := smtp.SendMail(e.Addr, e.Auth, e.From, []string{m.to}, buildEmail(e.From, m.to, m.n, time.Now()))
+		if err != nil {
+			log.Println("could not send email", err)
+		}
+		if m.done != nil {
+			m.done(nil, err)
+		}
+	}
+}
+
+// Close stops accepting emails and waits until queued emails are sent.
+func (e *EmailNotifier) Close() {
+	close(e.messages)
+	<-e.done
+}
+
+// buildEmail returns an HTML email with the notification, lines of the text are kept.
+func buildEmail(from, to string, n Notification, now time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/html; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.TrimRight(n.Text, "\n"), "\n", "<br>\r\n"))
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
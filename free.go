@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const freeFormat = `expected format: "Tue", "today 18:00-20:00", "2026-11-07 10:00-14:00 x2" or "Sat @venue"`
+
+// FreeQuery is a request for free courts on a day.
+type FreeQuery struct {
+	// Date is the midnight of the day in the venue timezone
+	Date time.Time
+	// From and To limit the time of the day, zero To means until closing
+	From, To Clock
+	Courts   int
+	Venue    VenueInfo
+}
+
+// ParseFreeQuery parses "<day|date|today|tomorrow> [from-to] [xCourts] [@venue]", a day is the next such day from now.
+func ParseFreeQuery(input string, now time.Time) (FreeQuery, error) {
+	data := strings.Fields(strings.ToLower(input))
+	data, opts, err := parseOptions(data, 1, optionCourts, optionVenue)
+	if err != nil {
+		return FreeQuery{}, err
+	}
+	if len(data) < 1 || len(data) > 2 {
+		return FreeQuery{}, fmt.Errorf("incorrect query, %s", freeFormat)
+	}
+	venue, _ := Venues.Get(opts.venue)
+	q := FreeQuery{Courts: opts.courts, Venue: venue.Info()}
+	now = now.In(q.Venue.Location)
+	q.Date, err = parseDay(data[0], today(now))
+	if err != nil {
+		return FreeQuery{}, err
+	}
+	if err := checkWeek(q.Date, today(now)); err != nil {
+		return FreeQuery{}, err
+	}
+	if len(data) == 2 {
+		clocks := strings.Split(data[1], "-")
+		if len(clocks) != 2 {
+			return FreeQuery{}, fmt.Errorf("incorrect time range: \"%s\"", data[1])
+		}
+		if q.From, err = parseSlotTime(clocks[0]); err != nil {
+			return FreeQuery{}, err
+		}
+		if q.To, err = parseSlotTime(clocks[1]); err != nil {
+			return FreeQuery{}, err
+		}
+		if q.To.Minutes() <= q.From.Minutes() {
+			return FreeQuery{}, errors.New("end time must be after start time")
+		}
+	}
+	return q, nil
+}
+
+// parseDay parses a date, "today", "tomorrow" or a weekday which is the next such day from today.
+func parseDay(input string, today time.Time) (time.Time, error) {
+	switch input {
+	case "today":
+		return today, nil
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), nil
+	}
+	if date, err := time.ParseInLocation(dateLayout, input, today.Location()); err == nil {
+		return date, nil
+	}
+	day, ok := weekdayMapping[input]
+	if !ok {
+		return time.Time{}, fmt.Errorf("unknown day: \"%s\", %s", input, freeFormat)
+	}
+	return today.AddDate(0, 0, (int(day)-int(today.Weekday())+7)%7), nil
+}
+
+// checkWeek checks the day is covered by calendars fetched on the first day.
+func checkWeek(day, first time.Time) error {
+	if day.Before(first) || !day.Before(first.AddDate(0, 0, 7)) {
+		return errors.New("day must be within the next week")
+	}
+	return nil
+}
+
+// Free returns slots of the query with at least the requested number of free courts.
+func (cal Calendar) Free(q FreeQuery) Calendar {
+	at := func(c Clock) time.Time {
+		return time.Date(q.Date.Year(), q.Date.Month(), q.Date.Day(), 0, c.Minutes(), 0, 0, q.Date.Location())
+	}
+	start, end := at(q.From), q.Date.AddDate(0, 0, 1)
+	if q.To != (Clock{}) {
+		end = at(q.To)
+	}
+	result := make(Calendar)
+	for t, courts := range cal {
+		if t.Before(start) || !t.Before(end) || len(courts) < q.Courts {
+			continue
+		}
+		result[t] = courts
+	}
+	return result
+}
+
+// Range is consecutive slots with the same free courts.
+type Range struct {
+	Start, End time.Time
+	Courts     Courts
+}
+
+// ranges merges consecutive slots with the same free courts, slots without free courts are skipped.
+func (cal Calendar) ranges() []Range {
+	var result []Range
+	for _, e := range cal.NonZero().toSlice() {
+		if n := len(result); n > 0 && result[n-1].End.Equal(e.Time) && result[n-1].Courts.String() == e.Courts.String() {
+			result[n-1].End = e.Time.Add(SlotDuration)
+			continue
+		}
+		result = append(result, Range{Start: e.Time, End: e.Time.Add(SlotDuration), Courts: e.Courts})
+	}
+	return result
+}
+
+// Ranges returns consecutive slots with the same free courts as "Tue 18:00-20:00: courts 2, 5, 9" lines.
+func (cal Calendar) Ranges() string {
+	var lines []string
+	for _, r := range cal.ranges() {
+		lines = append(lines, fmt.Sprintf("%s %s-%s: courts %s", r.Start.Format("Mon"), r.Start.Format("15:04"), r.End.Format("15:04"), r.Courts))
+	}
+	return strings.Join(lines, "\n")
+}
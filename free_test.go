@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFreeQuery(t *testing.T) {
+	// Sunday in the venue timezone
+	now := time.Date(2022, 2, 13, 9, 0, 0, 0, abaLocation)
+	day := func(d int) time.Time {
+		return time.Date(2022, 2, d, 0, 0, 0, 0, abaLocation)
+	}
+	aba := Venues[0].Info()
+	tests := []struct {
+		input string
+		want  FreeQuery
+	}{
+		{"sun", FreeQuery{Date: day(13), Courts: 1, Venue: aba}},
+		{"Tue", FreeQuery{Date: day(15), Courts: 1, Venue: aba}},
+		{"tomorrow 18:00-20:00", FreeQuery{Date: day(14), From: Clock{Hour: 18}, To: Clock{Hour: 20}, Courts: 1, Venue: aba}},
+		{"2022-02-19 10:00-12:30 x2 @aba", FreeQuery{Date: day(19), From: Clock{Hour: 10}, To: Clock{Hour: 12, Minute: 30}, Courts: 2, Venue: aba}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			q, err := ParseFreeQuery(tt.input, now)
+			require.NoError(t, err)
+			assert.True(t, tt.want.Date.Equal(q.Date), q.Date)
+			q.Date = tt.want.Date
+			assert.Equal(t, tt.want, q)
+		})
+	}
+
+	for _, input := range []string{"", "someday", "2022-02-12", "2022-02-20", "tue 18:00", "tue 20:00-18:00", "tue 18:15-20:00", "tue x0", "tue @nowhere", "tue 18:00-20:00 extra", "tue switch", "tue book", "tue p4"} {
+		_, err := ParseFreeQuery(input, now)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestCalendar_Free(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2022, 2, day, hour, minute, 0, 0, abaLocation)
+	}
+	cal := Calendar{
+		at(15, 17, 30): {1, 2},
+		at(15, 18, 0):  {2, 5, 9},
+		at(15, 18, 30): {2, 5, 9},
+		at(15, 19, 0):  {2, 5, 9},
+		at(15, 19, 30): {5},
+		at(15, 20, 0):  {},
+		at(16, 18, 0):  {1, 2},
+	}
+	q := FreeQuery{Date: at(15, 0, 0), From: Clock{Hour: 18}, To: Clock{Hour: 20, Minute: 30}, Courts: 1}
+	assert.Equal(t, "Tue 18:00-19:30: courts 2, 5, 9\nTue 19:30-20:00: courts 5", cal.Free(q).Ranges())
+
+	q.Courts = 2
+	assert.Equal(t, "Tue 18:00-19:30: courts 2, 5, 9", cal.Free(q).Ranges())
+
+	// the whole day
+	q = FreeQuery{Date: at(16, 0, 0), Courts: 1}
+	assert.Equal(t, "Wed 18:00-18:30: courts 1, 2", cal.Free(q).Ranges())
+}
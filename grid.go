@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Grid cell size and margins in pixels.
+const (
+	gridCell   = 16
+	gridLeft   = 24
+	gridTop    = 20
+	gridMarker = 4
+	// gridScale is the size of a pixel of digit glyphs
+	gridScale = 2
+)
+
+var (
+	gridBackground = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	gridFree       = color.RGBA{R: 76, G: 175, B: 80, A: 255}
+	gridBooked     = color.RGBA{R: 224, G: 224, B: 224, A: 255}
+	gridSubscribed = color.RGBA{R: 255, G: 152, B: 0, A: 255}
+	gridText       = color.RGBA{R: 33, G: 33, B: 33, A: 255}
+)
+
+// digitGlyphs are 3x5 pixel digits, every row is 3 bits with the leftmost pixel in the highest bit.
+var digitGlyphs = [10][5]uint8{
+	{7, 5, 5, 5, 7},
+	{2, 6, 2, 2, 7},
+	{7, 1, 7, 4, 7},
+	{7, 1, 7, 1, 7},
+	{5, 5, 7, 1, 1},
+	{7, 4, 7, 1, 7},
+	{7, 4, 7, 5, 7},
+	{7, 1, 1, 1, 1},
+	{7, 5, 7, 5, 7},
+	{7, 5, 7, 1, 7},
+}
+
+// drawNumber draws n with the top left corner at x, y.
+func drawNumber(img draw.Image, x, y, n int) {
+	for _, d := range strconv.Itoa(n) {
+		glyph := digitGlyphs[d-'0']
+		for row, bits := range glyph {
+			for col := 0; col < 3; col++ {
+				if bits&(4>>col) == 0 {
+					continue
+				}
+				px := image.Rect(x+col*gridScale, y+row*gridScale, x+(col+1)*gridScale, y+(row+1)*gridScale)
+				draw.Draw(img, px, image.NewUniform(gridText), image.Point{}, draw.Src)
+			}
+		}
+		x += 4 * gridScale
+	}
+}
+
+// Grid renders courts of the venue by slots of the day from opening to closing, free courts are green.
+// Slots in highlight are marked above the grid.
+func (cal Calendar) Grid(venue VenueInfo, day time.Time, highlight map[time.Time]bool) *image.RGBA {
+	day = today(day.In(venue.Location))
+	step := int(SlotDuration / time.Minute)
+	var slots []time.Time
+	for m := venue.Open.Minutes(); m < venue.Close.Minutes(); m += step {
+		slots = append(slots, time.Date(day.Year(), day.Month(), day.Day(), 0, m, 0, 0, venue.Location))
+	}
+	width := gridLeft + len(slots)*gridCell + 1
+	height := gridTop + len(venue.Courts)*gridCell + 1
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(gridBackground), image.Point{}, draw.Src)
+
+	for i, t := range slots {
+		x := gridLeft + i*gridCell
+		if t.Minute() == 0 && t.Hour()%2 == 0 {
+			drawNumber(img, x, 2, t.Hour())
+		}
+		if highlight[t] {
+			marker := image.Rect(x, gridTop-gridMarker-1, x+gridCell, gridTop-1)
+			draw.Draw(img, marker, image.NewUniform(gridSubscribed), image.Point{}, draw.Src)
+		}
+		free, ok := cal[t]
+		for j, court := range venue.Courts {
+			c := gridBooked
+			if !ok {
+				c = gridBackground
+			} else if free.has(court) {
+				c = gridFree
+			}
+			y := gridTop + j*gridCell
+			// keep 1px gaps between cells
+			draw.Draw(img, image.Rect(x+1, y+1, x+gridCell, y+gridCell), image.NewUniform(c), image.Point{}, draw.Src)
+		}
+	}
+	for j, court := range venue.Courts {
+		drawNumber(img, 2, gridTop+j*gridCell+3, court)
+	}
+	return img
+}
+
+// subscribedSlots returns slots of the day which are covered by subscriptions of the user to the venue.
+func subscribedSlots(user *UserData, venue VenueInfo, day time.Time) map[time.Time]bool {
+	result := make(map[time.Time]bool)
+	if user == nil {
+		return result
+	}
+	day = today(day.In(venue.Location))
+	step := int(SlotDuration / time.Minute)
+	for _, sub := range user.Subscriptions {
+		if sub.Paused || sub.VenueID() != venue.ID || !sub.MatchesDay(day) {
+			continue
+		}
+		end := sub.Time.Minutes() + sub.Minutes
+		if sub.IsWindow() {
+			end = sub.End.Minutes()
+		}
+		for m := sub.Time.Minutes(); m < end; m += step {
+			result[time.Date(day.Year(), day.Month(), day.Day(), 0, m, 0, 0, venue.Location)] = true
+		}
+	}
+	return result
+}
+
+// ParseGridQuery parses "[day|date|today|tomorrow] [@venue]" of /grid, the default day is today.
+func ParseGridQuery(input string, now time.Time) (VenueInfo, time.Time, error) {
+	data, opts, err := parseOptions(strings.Fields(strings.ToLower(input)), 0, optionVenue)
+	if err != nil {
+		return VenueInfo{}, time.Time{}, err
+	}
+	if len(data) > 1 {
+		return VenueInfo{}, time.Time{}, fmt.Errorf("incorrect day: \"%s\"", strings.Join(data, " "))
+	}
+	venue, _ := Venues.Get(opts.venue)
+	info := venue.Info()
+	first := today(now.In(info.Location))
+	day := first
+	if len(data) == 1 {
+		day, err = parseDay(data[0], first)
+		if err != nil {
+			return VenueInfo{}, time.Time{}, err
+		}
+	}
+	if err := checkWeek(day, first); err != nil {
+		return VenueInfo{}, time.Time{}, err
+	}
+	return info, day, nil
+}
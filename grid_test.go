@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalendar_Grid(t *testing.T) {
+	venue := VenueInfo{ID: "aba", Courts: Courts{1, 2, 12}, Open: Clock{Hour: 18}, Close: Clock{Hour: 20}, Location: abaLocation}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2022, 2, 13, hour, minute, 0, 0, abaLocation)
+	}
+	cal := Calendar{
+		at(18, 0):  {1, 12},
+		at(18, 30): {2},
+		at(19, 0):  {},
+	}
+	img := cal.Grid(venue, at(9, 0), map[time.Time]bool{at(18, 30): true})
+	assert.Equal(t, gridLeft+4*gridCell+1, img.Bounds().Dx())
+	assert.Equal(t, gridTop+3*gridCell+1, img.Bounds().Dy())
+
+	cell := func(slot, court int) (int, int) {
+		return gridLeft + slot*gridCell + gridCell/2, gridTop + court*gridCell + gridCell/2
+	}
+	for _, tt := range []struct {
+		slot, court int
+		want        interface{}
+	}{
+		{0, 0, gridFree},
+		{0, 1, gridBooked},
+		{0, 2, gridFree},
+		{1, 1, gridFree},
+		{2, 2, gridBooked},
+		// no data
+		{3, 0, gridBackground},
+	} {
+		x, y := cell(tt.slot, tt.court)
+		assert.Equal(t, tt.want, img.RGBAAt(x, y), "slot %d court %d", tt.slot, tt.court)
+	}
+	assert.Equal(t, gridSubscribed, img.RGBAAt(gridLeft+gridCell+gridCell/2, gridTop-2))
+	assert.Equal(t, gridBackground, img.RGBAAt(gridLeft+gridCell/2, gridTop-2))
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+}
+
+func TestSubscribedSlots(t *testing.T) {
+	venue := VenueInfo{ID: "aba", Location: abaLocation}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2022, 2, 13, hour, minute, 0, 0, abaLocation)
+	}
+	user := NewUserData("1")
+	user.Subscriptions = []Subscription{
+		{Days: NewWeekdays(time.Sunday), Time: Clock{Hour: 18}, Minutes: 60},
+		{Days: NewWeekdays(time.Sunday), Time: Clock{Hour: 8}, Minutes: 60, End: Clock{Hour: 9, Minute: 30}},
+		{Days: NewWeekdays(time.Monday), Time: Clock{Hour: 12}, Minutes: 60},
+		{Days: NewWeekdays(time.Sunday), Time: Clock{Hour: 14}, Minutes: 60, Paused: true},
+		{Days: NewWeekdays(time.Sunday), Time: Clock{Hour: 16}, Minutes: 60, Venue: "other"},
+	}
+	assert.Equal(t, map[time.Time]bool{
+		at(8, 0): true, at(8, 30): true, at(9, 0): true,
+		at(18, 0): true, at(18, 30): true,
+	}, subscribedSlots(user, venue, at(10, 0)))
+	assert.Empty(t, subscribedSlots(nil, venue, at(10, 0)))
+}
+
+func TestParseGridQuery(t *testing.T) {
+	now := time.Date(2022, 2, 13, 9, 0, 0, 0, abaLocation)
+	venue, day, err := ParseGridQuery("", now)
+	require.NoError(t, err)
+	assert.Equal(t, "aba", venue.ID)
+	assert.True(t, day.Equal(time.Date(2022, 2, 13, 0, 0, 0, 0, abaLocation)))
+
+	_, day, err = ParseGridQuery("Wed @aba", now)
+	require.NoError(t, err)
+	assert.True(t, day.Equal(time.Date(2022, 2, 16, 0, 0, 0, 0, abaLocation)))
+
+	for _, input := range []string{"someday", "2022-02-01", "tue wed", "@nowhere", "tue x2", "tue switch"} {
+		_, _, err := ParseGridQuery(input, now)
+		assert.Error(t, err, input)
+	}
+}
@@ -1,21 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
 	"github.com/joho/godotenv"
 	"gopkg.in/telebot.v3/middleware"
+	"image/png"
 	"log"
+	"net"
+	"net/smtp"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	tele "gopkg.in/telebot.v3"
 )
 
-const CourtsCount = 12
 const MinBookingDuration = 60 * time.Minute
-const StartHour = 6
-const EndHour = 22
+const SlotDuration = 30 * time.Minute
 
 func main() {
 	err := godotenv.Load(".env")
@@ -24,29 +28,174 @@ func main() {
 		Poller: &tele.LongPoller{Timeout: 10 * time.Second},
 	}
 
-	store, err := NewStore("data.json")
+	importFile := flag.String("import", "", "import users from JSON file to DATA_FILE and exit")
+	flag.Parse()
+
+	// venues are needed to migrate data
+	venuesFile := os.Getenv("VENUES_FILE")
+	if venuesFile == "" {
+		venuesFile = "venues.json"
+	}
+	Venues, err = LoadVenues(venuesFile)
+	checkErr(err)
+
+	dataFile := os.Getenv("DATA_FILE")
+	if dataFile == "" {
+		dataFile = "data.json"
+	}
+	if *importFile != "" {
+		storage, err := OpenStorage(dataFile)
+		checkErr(err)
+		n, err := ImportJSON(*importFile, storage)
+		checkErr(err)
+		checkErr(storage.Close())
+		log.Printf("imported %d users from %s to %s", n, *importFile, dataFile)
+		return
+	}
+
+	store, err := NewStore(dataFile)
 	checkErr(err)
+	defer store.Close()
+	// auto-booking is disabled without a key to encrypt credentials
+	if key := os.Getenv("CREDENTIALS_KEY"); key != "" {
+		store.Cipher, err = NewCipher(key)
+		checkErr(err)
+	}
 
 	b, err := tele.NewBot(pref)
 	checkErr(err)
 	b.Use(middleware.Logger())
 
+	// Telegram messages are sent by a single worker to respect Telegram rate limits
+	queue := NewSendQueue(b, 1000)
+	go queue.Run()
+	store.Queue = queue
+	store.Notifiers = map[string]Notifier{ChannelTelegram: &TelegramNotifier{Queue: queue}}
+	// email notifications are disabled without an SMTP server
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		var auth smtp.Auth
+		if username := os.Getenv("SMTP_USERNAME"); username != "" {
+			host, _, err := net.SplitHostPort(addr)
+			checkErr(err)
+			auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+		}
+		email := NewEmailNotifier(addr, os.Getenv("SMTP_FROM"), auth, 1000)
+		go email.Run()
+		store.Notifiers[ChannelEmail] = email
+	}
+
+	b.Use(ignoreBanned(store))
+	adminIDs, err := parseAdminIDs(os.Getenv("ADMIN_IDS"))
+	checkErr(err)
+	botAdmins := b.Group()
+	botAdmins.Use(botAdminOnly(adminIDs))
+	handleAdmin(botAdmins, store)
+
+	// subscriptions of groups can only be changed by chat admins
+	admins := b.Group()
+	admins.Use(adminOnly(chatAdmin(b)))
+
 	b.Handle("/all", func(c tele.Context) error {
-		cal, err := fetchCalendar()
+		venue, ok := Venues.Get(strings.TrimPrefix(strings.ToLower(c.Data()), "@"))
+		if !ok {
+			return c.Send(fmt.Sprintf("Unknown venue, available venues:\n%s", Venues))
+		}
+		cal, err := fetchCalendar(venue, time.Now())
 		if err != nil {
 			return c.Send(err.Error())
 		}
-		return c.Send(limitString(fmt.Sprintf("%v", cal.String()), 4096))
+		id := chatID(c)
+		text, markup := availabilityPage(venue.Info(), cal.In(store.Location(id)), 0)
+		return c.Send(text, markup, tele.ModeHTML)
 	})
+	handleAvailability(b, store)
 
+	b.Handle("/free", func(c tele.Context) error {
+		q, err := ParseFreeQuery(c.Data(), time.Now())
+		if err != nil {
+			return c.Send(err.Error())
+		}
+		venue, _ := Venues.Get(q.Venue.ID)
+		cal, err := fetchCalendar(venue, time.Now())
+		if err != nil {
+			return c.Send(err.Error())
+		}
+		free := cal.Free(q)
+		if len(free) == 0 {
+			return c.Send("No free courts")
+		}
+		id := chatID(c)
+		for _, msg := range splitMessage(free.In(store.Location(id)).Ranges(), MessageLimit) {
+			if err := c.Send(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	b.Handle("/grid", func(c tele.Context) error {
+		info, day, err := ParseGridQuery(c.Data(), time.Now())
+		if err != nil {
+			return c.Send(err.Error())
+		}
+		venue, _ := Venues.Get(info.ID)
+		cal, err := fetchCalendar(venue, time.Now())
+		if err != nil {
+			return c.Send(err.Error())
+		}
+		user, _ := store.User(chatID(c))
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, cal.Grid(info, day, subscribedSlots(user, info, day))); err != nil {
+			return err
+		}
+		caption := fmt.Sprintf("%s, %s", info.Name, day.Format("Mon 2 Jan"))
+		return c.Send(&tele.Photo{File: tele.FromReader(&buf), Caption: caption})
+	})
+
+	b.Handle("/venues", func(c tele.Context) error {
+		return c.Send(fmt.Sprintf("Available venues:\n%s", Venues))
+	})
+
+	handleManage(admins, store)
 	b.Handle("/list", func(c tele.Context) error {
-		id := strconv.FormatInt(c.Sender().ID, 10)
-		msg := fmt.Sprintf("Current subscriptions:\n%s", store.Subscriptions(id))
-		return c.Send(msg)
+		user, _ := store.User(chatID(c))
+		text, markup := listStep(user, time.Now())
+		return c.Send(text, markup)
+	})
+
+	// "/edit 2 Mon 18:00 2" replaces the second subscription in /list, "/edit" shows buttons
+	admins.Handle("/edit", func(c tele.Context) error {
+		id := chatID(c)
+		user, _ := store.User(id)
+		args := strings.Fields(c.Data())
+		if len(args) == 0 {
+			text, markup := listStep(user, time.Now())
+			return c.Send(text, markup)
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || user == nil || n < 1 || n > len(user.Subscriptions) {
+			return c.Send("Unknown subscription number, see /list")
+		}
+		err = store.ReplaceSubscription(id, user.Subscriptions[n-1].ID, strings.Join(args[1:], " "))
+		if err != nil {
+			return c.Send(err.Error())
+		}
+		user, _ = store.User(id)
+		text, markup := listStep(user, time.Now())
+		return c.Send(text, markup)
 	})
 
-	b.Handle("/add", func(c tele.Context) error {
-		id := strconv.FormatInt(c.Sender().ID, 10)
+	handleAddWizard(admins, store)
+	handlePolls(b, store)
+	admins.Handle("/add", func(c tele.Context) error {
+		if c.Data() == "" {
+			text, markup, err := wizardStep(wizardState{}, "")
+			if err != nil {
+				return c.Send(err.Error())
+			}
+			return c.Send(text, markup)
+		}
+		id := chatID(c)
 		err := store.Subscribe(id, c.Data())
 		if err != nil {
 			return c.Send(err.Error())
@@ -55,8 +204,8 @@ func main() {
 		return c.Send(msg)
 	})
 
-	b.Handle("/remove", func(c tele.Context) error {
-		id := strconv.FormatInt(c.Sender().ID, 10)
+	admins.Handle("/remove", func(c tele.Context) error {
+		id := chatID(c)
 		err := store.Unsubscribe(id, c.Data())
 		if err != nil {
 			return c.Send(err.Error())
@@ -65,8 +214,110 @@ func main() {
 		return c.Send(msg)
 	})
 
-	b.Handle("/clean", func(c tele.Context) error {
-		id := strconv.FormatInt(c.Sender().ID, 10)
+	admins.Handle("/pause", func(c tele.Context) error {
+		id := chatID(c)
+		data := strings.TrimSpace(c.Data())
+		var err error
+		var msg string
+		switch {
+		case data == "":
+			err = store.Pause(id, "")
+			msg = "All notifications paused, use /resume to restart them"
+		case strings.HasPrefix(strings.ToLower(data), "until "):
+			until := strings.TrimSpace(data[len("until "):])
+			err = store.Pause(id, until)
+			msg = fmt.Sprintf("All notifications paused until %s", until)
+		default:
+			err = store.PauseSubscription(id, data, true)
+			msg = fmt.Sprintf("Paused. Current subscriptions:\n%s", store.Subscriptions(id))
+		}
+		if err != nil {
+			return c.Send(err.Error())
+		}
+		return c.Send(msg)
+	})
+
+	admins.Handle("/resume", func(c tele.Context) error {
+		id := chatID(c)
+		var err error
+		if data := strings.TrimSpace(c.Data()); data != "" {
+			err = store.PauseSubscription(id, data, false)
+		} else {
+			err = store.Resume(id)
+		}
+		if err != nil {
+			return c.Send(err.Error())
+		}
+		return c.Send(fmt.Sprintf("Resumed. Current subscriptions:\n%s", store.Subscriptions(id)))
+	})
+
+	admins.Handle("/timezone", func(c tele.Context) error {
+		id := chatID(c)
+		if data := strings.TrimSpace(c.Data()); data != "" {
+			if strings.EqualFold(data, "venue") {
+				data = ""
+			}
+			if err := store.SetTimeZone(id, data); err != nil {
+				return c.Send(err.Error())
+			}
+		}
+		return c.Send(fmt.Sprintf("Times are shown in %s timezone", store.Location(id)))
+	})
+
+	// "/login @venue username password" saves credentials for auto-booking, the message is deleted
+	b.Handle("/login", func(c tele.Context) error {
+		if err := c.Delete(); err != nil {
+			log.Println("could not delete credentials message", err)
+		}
+		if c.Chat().Type != tele.ChatPrivate {
+			return c.Send("Send /login in a private chat with the bot")
+		}
+		args := strings.Fields(c.Data())
+		if len(args) != 3 || !strings.HasPrefix(args[0], "@") {
+			return c.Send("Expected format: /login @venue username password")
+		}
+		id := chatID(c)
+		venueID := strings.ToLower(strings.TrimPrefix(args[0], "@"))
+		err := store.SetCredentials(id, venueID, Credentials{Username: args[1], Password: args[2]})
+		if err != nil {
+			return c.Send(err.Error())
+		}
+		return c.Send(fmt.Sprintf("Credentials saved, add \"book\" to subscriptions at %s to book courts automatically", venueID))
+	})
+
+	admins.Handle("/logout", func(c tele.Context) error {
+		venue, ok := Venues.Get(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(c.Data())), "@"))
+		if !ok {
+			return c.Send(fmt.Sprintf("Unknown venue, available venues:\n%s", Venues))
+		}
+		id := chatID(c)
+		if err := store.DeleteCredentials(id, venue.Info().ID); err != nil {
+			return c.Send(err.Error())
+		}
+		return c.Send("Credentials deleted")
+	})
+
+	// "/channels telegram email bob@example.com" sends notifications to both, "/channels" shows them
+	admins.Handle("/channels", func(c tele.Context) error {
+		id := chatID(c)
+		if data := strings.TrimSpace(c.Data()); data != "" {
+			channels, err := ParseChannels(data)
+			if err != nil {
+				return c.Send(err.Error())
+			}
+			if err := store.SetChannels(id, channels); err != nil {
+				return c.Send(err.Error())
+			}
+		}
+		user, ok := store.User(id)
+		if !ok {
+			user = NewUserData(id)
+		}
+		return c.Send(fmt.Sprintf("Notifications are sent by:\n%s", channelsString(user)))
+	})
+
+	admins.Handle("/clean", func(c tele.Context) error {
+		id := chatID(c)
 		err := store.DeleteUser(id)
 		if err != nil {
 			return c.Send(err.Error())
@@ -84,12 +335,15 @@ func main() {
 		defer ticker.Stop()
 
 		check := func() {
-			cal, err := fetchCalendar()
-			if err != nil {
-				log.Println(err)
-				return
+			store.ExpireAll(time.Now())
+			for _, venue := range Venues {
+				cal, err := fetchCalendar(venue, time.Now())
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				store.NotifyAll(venue.Info(), cal)
 			}
-			store.NotifyAll(b, cal)
 		}
 
 		check()
@@ -107,10 +361,3 @@ func checkErr(err error) {
 		log.Fatal(err)
 	}
 }
-
-func limitString(in string, l int) string {
-	if len(in) > l {
-		return in[:l]
-	}
-	return in
-}
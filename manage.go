@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Callback uniques of the subscription management buttons.
+const (
+	manageRemove = "sub_remove"
+	manageEdit   = "sub_edit"
+	manageCopy   = "sub_copy"
+	managePause  = "sub_pause"
+	manageShift  = "sub_shift"
+	manageResize = "sub_resize"
+	manageDone   = "sub_done"
+)
+
+// listStep returns the numbered subscriptions of the user with Remove, Edit, Pause and Duplicate buttons for each of them.
+func listStep(user *UserData, now time.Time) (string, *tele.ReplyMarkup) {
+	if user == nil || len(user.Subscriptions) == 0 {
+		return "Current subscriptions:\nno subscriptions", nil
+	}
+	m := &tele.ReplyMarkup{}
+	lines := []string{"Current subscriptions:"}
+	if user.IsPaused(now) {
+		if user.PausedUntil != "" {
+			lines = append(lines, fmt.Sprintf("All notifications are paused until %s", user.PausedUntil))
+		} else {
+			lines = append(lines, "All notifications are paused")
+		}
+	}
+	var rows []tele.Row
+	for i, sub := range user.Subscriptions {
+		n := strconv.Itoa(i + 1)
+		line := fmt.Sprintf("%s. %s", n, sub.String())
+		pause := m.Data("Pause "+n, managePause, sub.ID, "1")
+		if sub.Paused {
+			line += " (paused)"
+			pause = m.Data("Resume "+n, managePause, sub.ID, "0")
+		}
+		lines = append(lines, line)
+		rows = append(rows, m.Row(
+			m.Data("Remove "+n, manageRemove, sub.ID),
+			m.Data("Edit "+n, manageEdit, sub.ID),
+			pause,
+			m.Data("Duplicate "+n, manageCopy, sub.ID),
+		))
+	}
+	m.Inline(rows...)
+	return strings.Join(lines, "\n"), m
+}
+
+// editStep returns buttons which move the start time and change the duration of the subscription.
+func editStep(sub Subscription) (string, *tele.ReplyMarkup) {
+	m := &tele.ReplyMarkup{}
+	step := strconv.Itoa(int(SlotDuration / time.Minute))
+	label := formatMinutes(int(SlotDuration / time.Minute))
+	m.Inline(
+		m.Row(m.Data("Start -"+label, manageShift, sub.ID, "-"+step), m.Data("Start +"+label, manageShift, sub.ID, step)),
+		m.Row(m.Data("Duration -"+label, manageResize, sub.ID, "-"+step), m.Data("Duration +"+label, manageResize, sub.ID, step)),
+		m.Row(m.Data("Done", manageDone)),
+	)
+	return fmt.Sprintf("Editing %s", sub.String()), m
+}
+
+// shift moves the subscription start by minutes, windows are moved as a whole.
+func (r *Subscription) shift(minutes int) {
+	r.Time = r.Time.Add(minutes)
+	if r.IsWindow() {
+		r.End = r.End.Add(minutes)
+	}
+}
+
+// resize changes the duration by minutes, a window which is filled by the booking becomes a fixed time subscription.
+func (r *Subscription) resize(minutes int) {
+	r.Minutes += minutes
+	if r.IsWindow() && r.Time.Add(r.Minutes) == r.End {
+		r.End = Clock{}
+	}
+}
+
+// subscriptionCallback parses "<subscription ID>|<value>" callback data.
+func subscriptionCallback(data string) (string, int, error) {
+	fields := strings.Split(data, "|")
+	if len(fields) != 2 {
+		return "", 0, fmt.Errorf("incorrect callback data: %q", data)
+	}
+	value, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return "", 0, fmt.Errorf("incorrect callback data: %q", data)
+	}
+	return fields[0], value, nil
+}
+
+// handleManage registers callbacks of the subscription management buttons.
+func handleManage(b *tele.Group, store *Store) {
+	showList := func(c tele.Context, id string) error {
+		user, _ := store.User(id)
+		text, markup := listStep(user, time.Now())
+		return c.Edit(text, markup)
+	}
+	showEdit := func(c tele.Context, id, subID string) error {
+		user, ok := store.User(id)
+		if !ok {
+			return showList(c, id)
+		}
+		i, err := user.subscription(subID)
+		if err != nil {
+			return showList(c, id)
+		}
+		text, markup := editStep(user.Subscriptions[i])
+		return c.Edit(text, markup)
+	}
+	// failed shows the error as a notification so the user stays on the same keyboard
+	failed := func(c tele.Context, err error) error {
+		return c.Respond(&tele.CallbackResponse{Text: err.Error()})
+	}
+
+	b.Handle(&tele.Btn{Unique: manageRemove}, func(c tele.Context) error {
+		id := chatID(c)
+		if err := store.RemoveSubscription(id, c.Data()); err != nil {
+			return failed(c, err)
+		}
+		if err := c.Respond(); err != nil {
+			return err
+		}
+		return showList(c, id)
+	})
+	b.Handle(&tele.Btn{Unique: managePause}, func(c tele.Context) error {
+		id := chatID(c)
+		subID, paused, err := subscriptionCallback(c.Data())
+		if err != nil {
+			return err
+		}
+		err = store.EditSubscription(id, subID, func(sub *Subscription) error {
+			sub.Paused = paused == 1
+			return nil
+		})
+		if err != nil {
+			return failed(c, err)
+		}
+		if err := c.Respond(); err != nil {
+			return err
+		}
+		return showList(c, id)
+	})
+	b.Handle(&tele.Btn{Unique: manageEdit}, func(c tele.Context) error {
+		if err := c.Respond(); err != nil {
+			return err
+		}
+		return showEdit(c, chatID(c), c.Data())
+	})
+	// the copy is opened for editing, so it can be moved to the right time
+	b.Handle(&tele.Btn{Unique: manageCopy}, func(c tele.Context) error {
+		id := chatID(c)
+		copyID, err := store.DuplicateSubscription(id, c.Data())
+		if err != nil {
+			return failed(c, err)
+		}
+		if err := c.Respond(); err != nil {
+			return err
+		}
+		return showEdit(c, id, copyID)
+	})
+	edit := func(change func(sub *Subscription, minutes int)) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			id := chatID(c)
+			subID, minutes, err := subscriptionCallback(c.Data())
+			if err != nil {
+				return err
+			}
+			err = store.EditSubscription(id, subID, func(sub *Subscription) error {
+				change(sub, minutes)
+				return nil
+			})
+			if err != nil {
+				return failed(c, err)
+			}
+			if err := c.Respond(); err != nil {
+				return err
+			}
+			return showEdit(c, id, subID)
+		}
+	}
+	b.Handle(&tele.Btn{Unique: manageShift}, edit((*Subscription).shift))
+	b.Handle(&tele.Btn{Unique: manageResize}, edit((*Subscription).resize))
+	b.Handle(&tele.Btn{Unique: manageDone}, func(c tele.Context) error {
+		if err := c.Respond(); err != nil {
+			return err
+		}
+		return showList(c, chatID(c))
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListStep(t *testing.T) {
+	text, markup := listStep(nil, time.Now())
+	assert.Equal(t, "Current subscriptions:\nno subscriptions", text)
+	assert.Nil(t, markup)
+
+	user := NewUserData("1")
+	user.Subscriptions = []Subscription{
+		{ID: "a", Days: NewWeekdays(time.Monday), Time: Clock{Hour: 18}, Minutes: 60},
+		{ID: "b", Days: Weekend, Time: Clock{Hour: 10}, Minutes: 120, Paused: true},
+	}
+	text, markup = listStep(user, time.Now())
+	assert.Equal(t, "Current subscriptions:\n1. Mon 18:00-19:00\n2. Weekend 10:00-12:00 (paused)", text)
+	unique, data := press(t, markup, "Remove 2")
+	assert.Equal(t, manageRemove, unique)
+	assert.Equal(t, "b", data)
+	_, data = press(t, markup, "Pause 1")
+	assert.Equal(t, "a|1", data)
+	_, data = press(t, markup, "Resume 2")
+	assert.Equal(t, "b|0", data)
+	_, data = press(t, markup, "Edit 1")
+	assert.Equal(t, "a", data)
+	unique, data = press(t, markup, "Duplicate 2")
+	assert.Equal(t, manageCopy, unique)
+	assert.Equal(t, "b", data)
+
+	_, markup = editStep(user.Subscriptions[0])
+	unique, data = press(t, markup, "Start -0.5h")
+	assert.Equal(t, manageShift, unique)
+	subID, minutes, err := subscriptionCallback(data)
+	require.NoError(t, err)
+	assert.Equal(t, "a", subID)
+	assert.Equal(t, -30, minutes)
+}
+
+func TestStore_EditSubscription(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	require.NoError(t, store.Subscribe("1", "Mon 17:00-20:00 2"))
+	require.NoError(t, store.Subscribe("1", "Tue 18:00"))
+	user, _ := store.User("1")
+	window, fixed := user.Subscriptions[0].ID, user.Subscriptions[1].ID
+	assert.NotEmpty(t, window)
+	assert.NotEqual(t, window, fixed)
+
+	shift := func(minutes int) func(sub *Subscription) error {
+		return func(sub *Subscription) error {
+			sub.shift(minutes)
+			return nil
+		}
+	}
+	resize := func(minutes int) func(sub *Subscription) error {
+		return func(sub *Subscription) error {
+			sub.resize(minutes)
+			return nil
+		}
+	}
+	require.NoError(t, store.EditSubscription("1", window, shift(30)))
+	assert.Equal(t, "Mon 17:30-20:30 2\nTue 18:00-19:00", store.Subscriptions("1"))
+	assert.Error(t, store.EditSubscription("1", window, resize(90)), "doesn't fit")
+	require.NoError(t, store.EditSubscription("1", window, resize(60)))
+	assert.Equal(t, "Mon 17:30-20:30\nTue 18:00-19:00", store.Subscriptions("1"))
+
+	require.NoError(t, store.EditSubscription("1", fixed, resize(30)))
+	assert.Error(t, store.EditSubscription("1", fixed, resize(-60)), "too short")
+	assert.Error(t, store.EditSubscription("1", fixed, shift(-19*60)), "before midnight")
+	assert.Error(t, store.EditSubscription("1", fixed, shift(5*60)), "after midnight")
+	assert.Error(t, store.EditSubscription("1", "x", shift(30)))
+	assert.Equal(t, "Mon 17:30-20:30\nTue 18:00-19:30", store.Subscriptions("1"))
+
+	require.NoError(t, store.PauseSubscription("1", "Tue 18:00 1.5", true))
+	require.NoError(t, store.ReplaceSubscription("1", fixed, "Wed 19:00 2"))
+	user, _ = store.User("1")
+	assert.Equal(t, Subscription{ID: fixed, Days: NewWeekdays(time.Wednesday), Time: Clock{Hour: 19}, Minutes: 120, Paused: true}, user.Subscriptions[1])
+	assert.Error(t, store.ReplaceSubscription("1", fixed, "Mon 17:30 3"), "duplicate")
+
+	require.NoError(t, store.RemoveSubscription("1", window))
+	assert.Equal(t, "Wed 19:00-21:00 (paused)", store.Subscriptions("1"))
+	assert.Error(t, store.RemoveSubscription("1", window))
+}
+
+func TestStore_DuplicateSubscription(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	require.NoError(t, store.Subscribe("1", "Mon 18:00 x2"))
+	require.NoError(t, store.Subscribe("1", "Mon 18:30 x2"))
+	require.NoError(t, store.Subscribe("1", "Tue 23:00"))
+	user, _ := store.User("1")
+	original := user.Subscriptions[0].ID
+
+	copyID, err := store.DuplicateSubscription("1", original)
+	require.NoError(t, err)
+	assert.NotEqual(t, original, copyID)
+	user, _ = store.User("1")
+	assert.Equal(t, copyID, user.Subscriptions[1].ID)
+	assert.Equal(t, "Mon 18:00-19:00 x2\nMon 19:00-20:00 x2\nMon 18:30-19:30 x2\nTue 23:00-24:00", store.Subscriptions("1"))
+
+	_, err = store.DuplicateSubscription("1", user.Subscriptions[3].ID)
+	assert.Error(t, err, "no time left")
+	_, err = store.DuplicateSubscription("1", "x")
+	assert.Error(t, err)
+}
+
+func TestNewStore_SubscriptionIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"version": 1, "users": {"1": {"id": "1", "subscriptions": [{"days": 2, "time": {"hours": 18}, "minutes": 60}]}}}`), 0600))
+	store, err := NewStore(path)
+	require.NoError(t, err)
+	user, _ := store.User("1")
+	id := user.Subscriptions[0].ID
+	assert.NotEmpty(t, id)
+
+	store, err = NewStore(path)
+	require.NoError(t, err)
+	user, _ = store.User("1")
+	assert.Equal(t, id, user.Subscriptions[0].ID, "IDs are saved")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+)
+
+// SentMessage is a notification about available slots, it's edited when the slots are booked again.
+type SentMessage struct {
+	ChatID    int64  `json:"chat_id"`
+	MessageID int    `json:"message_id"`
+	Venue     string `json:"venue"`
+	// Slots are the announced slots with free courts at the time of sending
+	Slots Calendar `json:"slots"`
+	// Text is the current text of the message
+	Text string `json:"text"`
+	// Players is the number of players needed, the message has "I'm in" and "Can't" buttons if it's set
+	Players int `json:"players,omitempty"`
+	// Responses are the answers of users by user ID, true for "I'm in"
+	Responses map[string]bool `json:"responses,omitempty"`
+	// Enough is set when enough players are in
+	Enough bool `json:"enough,omitempty"`
+}
+
+// expired reports whether all announced slots are in the past.
+func (m *SentMessage) expired(now time.Time) bool {
+	for t := range m.Slots {
+		if t.Add(SlotDuration).After(now) {
+			return false
+		}
+	}
+	return true
+}
+
+// renderNotification returns HTML text of a notification about announced slots.
+// Courts which are no longer free in cal are struck out, slots missing from cal are shown as announced.
+func renderNotification(venue VenueInfo, announced, cal Calendar, loc *time.Location) string {
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("New booking available at %s:\n", html.EscapeString(venue.Name)))
+	for _, e := range announced.toSlice() {
+		current, ok := cal[e.Time.In(venue.Location)]
+		if !ok {
+			current = e.Courts
+		}
+		free := e.Courts.intersect(current)
+		line := fmt.Sprintf("%s - courts ", e.Time.In(loc).Format("2006-01-02 15:04"))
+		switch {
+		case len(free) == 0:
+			buf.WriteString(fmt.Sprintf("<s>%s%s</s> taken\n", line, e.Courts))
+		case len(free) < len(e.Courts):
+			courts := make([]string, len(e.Courts))
+			for i, c := range e.Courts {
+				if free.has(c) {
+					courts[i] = fmt.Sprint(c)
+				} else {
+					courts[i] = fmt.Sprintf("<s>%d</s>", c)
+				}
+			}
+			buf.WriteString(fmt.Sprintf("%s%s\n", line, strings.Join(courts, ", ")))
+		default:
+			buf.WriteString(fmt.Sprintf("%s%s\n", line, e.Courts))
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderNotification(t *testing.T) {
+	venue := VenueInfo{ID: "aba", Name: "A&B", Location: abaLocation}
+	announced := Calendar{
+		time.Date(2022, 2, 13, 18, 0, 0, 0, abaLocation):  {1, 2},
+		time.Date(2022, 2, 13, 18, 30, 0, 0, abaLocation): {1, 2},
+		time.Date(2022, 2, 13, 19, 0, 0, 0, abaLocation):  {3},
+	}
+	assert.Equal(t, "New booking available at A&amp;B:\n"+
+		"2022-02-13 18:00 - courts 1, 2\n"+
+		"2022-02-13 18:30 - courts 1, 2\n"+
+		"2022-02-13 19:00 - courts 3\n", renderNotification(venue, announced, announced, abaLocation))
+
+	// announced slots are stored in JSON, their times lose the location
+	var stored SentMessage
+	data, err := json.Marshal(SentMessage{Slots: announced})
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &stored))
+
+	cal := Calendar{
+		time.Date(2022, 2, 13, 18, 0, 0, 0, abaLocation):  {1, 2, 5},
+		time.Date(2022, 2, 13, 18, 30, 0, 0, abaLocation): {1},
+		time.Date(2022, 2, 13, 19, 0, 0, 0, abaLocation):  {},
+	}
+	assert.Equal(t, "New booking available at A&amp;B:\n"+
+		"2022-02-13 18:00 - courts 1, 2\n"+
+		"2022-02-13 18:30 - courts 1, <s>2</s>\n"+
+		"<s>2022-02-13 19:00 - courts 3</s> taken\n", renderNotification(venue, stored.Slots, cal, abaLocation))
+}
+
+func TestSentMessage_expired(t *testing.T) {
+	m := SentMessage{Slots: Calendar{
+		time.Date(2022, 2, 13, 18, 0, 0, 0, abaLocation):  {1},
+		time.Date(2022, 2, 13, 18, 30, 0, 0, abaLocation): {1},
+	}}
+	assert.False(t, m.expired(time.Date(2022, 2, 13, 18, 45, 0, 0, abaLocation)))
+	assert.True(t, m.expired(time.Date(2022, 2, 13, 19, 0, 0, 0, abaLocation)))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DataVersion is the version of Data written to JSON files.
+const DataVersion = 2
+
+// rawData is Data decoded without types so migrations can change the format.
+type rawData map[string]interface{}
+
+// migrations upgrade saved Data, migrations[i] upgrades version i to i+1.
+// Files saved before versioning was introduced have version 0.
+var migrations = []func(data rawData) error{
+	migrateV1,
+	migrateV2,
+}
+
+// migrateData upgrades JSON encoded Data to DataVersion.
+func migrateData(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// keep large numbers like chat IDs intact
+	dec.UseNumber()
+	var raw rawData
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	version := 0
+	if v, ok := raw["version"].(json.Number); ok {
+		n, err := v.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("incorrect data version: %s", v)
+		}
+		version = int(n)
+	}
+	if version == DataVersion {
+		return data, nil
+	}
+	if version > DataVersion {
+		return nil, fmt.Errorf("data version %d is newer than supported version %d", version, DataVersion)
+	}
+	for ; version < DataVersion; version++ {
+		if err := migrations[version](raw); err != nil {
+			return nil, fmt.Errorf("could not migrate data to version %d: %w", version+1, err)
+		}
+		raw["version"] = version + 1
+	}
+	return json.Marshal(raw)
+}
+
+// users returns raw users of the data.
+func (d rawData) users() []map[string]interface{} {
+	users, _ := d["users"].(map[string]interface{})
+	var result []map[string]interface{}
+	for _, user := range users {
+		if user, ok := user.(map[string]interface{}); ok {
+			result = append(result, user)
+		}
+	}
+	return result
+}
+
+// migrateV1 converts data saved before day groups, half-hour slots and venues were introduced:
+// a subscription had a single "weekday" and a duration in "hours", notified slots had no venue.
+func migrateV1(data rawData) error {
+	for _, user := range data.users() {
+		subs, _ := user["subscriptions"].([]interface{})
+		for _, sub := range subs {
+			sub, ok := sub.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if weekday, ok := sub["weekday"].(json.Number); ok {
+				day, err := weekday.Int64()
+				if err != nil {
+					return fmt.Errorf("incorrect weekday: %s", weekday)
+				}
+				sub["days"] = NewWeekdays(time.Weekday(day))
+				delete(sub, "weekday")
+			}
+			if hours, ok := sub["hours"].(json.Number); ok {
+				n, err := hours.Int64()
+				if err != nil {
+					return fmt.Errorf("incorrect hours: %s", hours)
+				}
+				sub["minutes"] = n * 60
+				delete(sub, "hours")
+			}
+		}
+		notified, _ := user["notified"].(map[string]interface{})
+		for key, value := range notified {
+			if !strings.Contains(key, "@") {
+				delete(notified, key)
+				notified[Venues.DefaultID()+"@"+key] = value
+			}
+		}
+	}
+	return nil
+}
+
+// migrateV2 gives IDs to subscriptions saved before subscriptions had IDs.
+func migrateV2(data rawData) error {
+	for _, user := range data.users() {
+		subs, _ := user["subscriptions"].([]interface{})
+		for _, sub := range subs {
+			sub, ok := sub.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if id, _ := sub["id"].(string); id == "" {
+				sub["id"] = newSubscriptionID()
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeData(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		data, err := decodeData(nil)
+		require.NoError(t, err)
+		assert.Equal(t, Data{Version: DataVersion, Users: map[string]*UserData{}}, data)
+	})
+
+	t.Run("version 0", func(t *testing.T) {
+		data, err := decodeData([]byte(`{"users": {"1": {
+			"id": "1",
+			"subscriptions": [{"weekday": 0, "time": {"hours": 18, "minutes": 0}, "hours": 2}],
+			"notified": {"2022-02-13T19:00:00+13:00": {}, "north@2022-02-13T19:00:00+13:00": {}},
+			"messages": [{"chat_id": 9007199254740993, "message_id": 1}]
+		}}}`))
+		require.NoError(t, err)
+		assert.Equal(t, DataVersion, data.Version)
+		user := data.Users["1"]
+		require.NotNil(t, user)
+		require.Len(t, user.Subscriptions, 1)
+		assert.NotEmpty(t, user.Subscriptions[0].ID)
+		user.Subscriptions[0].ID = ""
+		assert.Equal(t, []Subscription{{Days: NewWeekdays(time.Sunday), Time: Clock{Hour: 18}, Minutes: 120}}, user.Subscriptions)
+		slot := time.Date(2022, 2, 13, 6, 0, 0, 0, time.UTC)
+		assert.Equal(t, map[Slot]struct{}{
+			{Venue: "aba", Time: slot}:   {},
+			{Venue: "north", Time: slot}: {},
+		}, user.Notified)
+		assert.Equal(t, int64(9007199254740993), user.Messages[0].ChatID)
+	})
+
+	t.Run("version 1", func(t *testing.T) {
+		data, err := decodeData([]byte(`{"version": 1, "users": {"1": {"id": "1", "subscriptions": [
+			{"days": 2, "minutes": 60},
+			{"id": "a1", "days": 4, "minutes": 60}
+		]}}}`))
+		require.NoError(t, err)
+		subs := data.Users["1"].Subscriptions
+		require.Len(t, subs, 2)
+		assert.Len(t, subs[0].ID, 8, "subscriptions get IDs")
+		assert.Equal(t, "a1", subs[1].ID)
+	})
+
+	t.Run("current version", func(t *testing.T) {
+		data, err := decodeData([]byte(`{"version": 2, "users": {"1": {"id": "1", "subscriptions": [{"id": "a1", "days": 2, "minutes": 60}]}}}`))
+		require.NoError(t, err)
+		assert.Equal(t, []Subscription{{ID: "a1", Days: NewWeekdays(time.Monday), Minutes: 60}}, data.Users["1"].Subscriptions)
+	})
+
+	t.Run("newer version", func(t *testing.T) {
+		_, err := decodeData([]byte(`{"version": 100, "users": {}}`))
+		assert.Error(t, err)
+	})
+}
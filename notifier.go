@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Notification channels users can choose.
+const (
+	ChannelTelegram = "telegram"
+	ChannelEmail    = "email"
+)
+
+// Notification is a message to a user, Text is HTML.
+type Notification struct {
+	Subject string
+	Text    string
+	// Venue and Slots are set for notifications about new slots
+	Venue VenueInfo
+	Slots Calendar
+	// Players is the number of players needed, channels which support it ask who's in
+	Players int
+}
+
+// Notifier delivers notifications over a channel in the background.
+type Notifier interface {
+	// Notify queues the notification to the address, done is called by a worker after delivery or failure if it isn't nil.
+	// sent is the message which can be edited later, it's nil for channels which don't support editing.
+	Notify(address string, n Notification, done func(sent *SentMessage, err error)) error
+}
+
+// TelegramNotifier sends notifications to chats through the send queue, the address is the chat ID.
+// Only group chats are asked who's in.
+type TelegramNotifier struct {
+	Queue *SendQueue
+}
+
+func (t *TelegramNotifier) Notify(address string, n Notification, done func(sent *SentMessage, err error)) error {
+	to, err := chatRecipient(address)
+	if err != nil {
+		return err
+	}
+	sent := SentMessage{Venue: n.Venue.ID, Slots: n.Slots, Text: n.Text}
+	// group IDs are negative
+	if strings.HasPrefix(address, "-") {
+		sent.Players = n.Players
+	}
+	out := OutMessage{To: to, What: n.Text, Opts: []interface{}{tele.ModeHTML, sent.pollMarkup()}}
+	if done != nil {
+		out.Sent = func(msg *tele.Message) {
+			sent.ChatID, sent.MessageID = msg.Chat.ID, msg.ID
+			done(&sent, nil)
+		}
+		out.Failed = func(err error) {
+			done(nil, err)
+		}
+	}
+	if !t.Queue.Enqueue(out) {
+		return errQueueFull
+	}
+	return nil
+}
+
+const channelsFormat = `expected format: "telegram", "email bob@example.com" or "telegram email bob@example.com"`
+
+// ParseChannels parses "[telegram] [email <address>]" into addresses by channel, the address of Telegram is blank.
+func ParseChannels(input string) (map[string]string, error) {
+	fields := strings.Fields(input)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no channels, %s", channelsFormat)
+	}
+	result := make(map[string]string)
+	for i := 0; i < len(fields); i++ {
+		switch strings.ToLower(fields[i]) {
+		case ChannelTelegram:
+			result[ChannelTelegram] = ""
+		case ChannelEmail:
+			if i+1 == len(fields) {
+				return nil, errors.New("email address is missing")
+			}
+			i++
+			addr, err := mail.ParseAddress(fields[i])
+			if err != nil {
+				return nil, fmt.Errorf("incorrect email address: \"%s\"", fields[i])
+			}
+			result[ChannelEmail] = addr.Address
+		default:
+			return nil, fmt.Errorf("unknown channel: \"%s\", %s", fields[i], channelsFormat)
+		}
+	}
+	return result, nil
+}
+
+// channelsString describes notification channels of the user.
+func channelsString(user *UserData) string {
+	var lines []string
+	for channel, address := range user.channels() {
+		if channel == ChannelTelegram {
+			lines = append(lines, "Telegram")
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s %s", channel, address))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
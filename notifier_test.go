@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNotifier delivers notifications when deliver is called.
+type fakeNotifier struct {
+	queued []func(err error)
+	texts  []string
+}
+
+func (f *fakeNotifier) Notify(address string, n Notification, done func(sent *SentMessage, err error)) error {
+	f.texts = append(f.texts, address+": "+n.Text)
+	f.queued = append(f.queued, func(err error) {
+		if done != nil {
+			done(nil, err)
+		}
+	})
+	return nil
+}
+
+func (f *fakeNotifier) deliver(err error) {
+	for _, done := range f.queued {
+		done(err)
+	}
+	f.queued = nil
+}
+
+// smtpServer is a minimal SMTP server accepting a single email, the email is sent to the returned channel.
+func smtpServer(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	emails := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		c := textproto.NewConn(conn)
+		c.PrintfLine("220 localhost ready")
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT", "RSET", "NOOP":
+				c.PrintfLine("250 OK")
+			case "DATA":
+				c.PrintfLine("354 go ahead")
+				data, err := c.ReadDotLines()
+				if err != nil {
+					return
+				}
+				emails <- strings.Join(data, "\n")
+				c.PrintfLine("250 OK")
+			case "QUIT":
+				c.PrintfLine("221 bye")
+				return
+			default:
+				c.PrintfLine("502 unknown command")
+			}
+		}
+	}()
+	return l.Addr().String(), emails
+}
+
+func TestEmailNotifier(t *testing.T) {
+	addr, emails := smtpServer(t)
+	e := NewEmailNotifier(addr, "bot@example.com", nil, 10)
+	go e.Run()
+
+	delivered := assert.AnError
+	n := Notification{Subject: "New booking available at ABA Stadium", Text: "2022-02-14 18:00 - courts 1\n2022-02-14 18:30 - courts <s>1</s>\n"}
+	require.NoError(t, e.Notify("bob@example.com", n, func(sent *SentMessage, err error) {
+		assert.Nil(t, sent)
+		delivered = err
+	}))
+	e.Close()
+	assert.NoError(t, delivered)
+
+	select {
+	case email := <-emails:
+		reader := textproto.NewReader(bufio.NewReader(strings.NewReader(email + "\n")))
+		header, err := reader.ReadMIMEHeader()
+		require.NoError(t, err)
+		assert.Equal(t, "bob@example.com", header.Get("To"))
+		assert.Equal(t, "bot@example.com", header.Get("From"))
+		assert.Equal(t, "New booking available at ABA Stadium", header.Get("Subject"))
+		assert.Equal(t, "text/html; charset=utf-8", header.Get("Content-Type"))
+		assert.Contains(t, email, "2022-02-14 18:00 - courts 1<br>\n2022-02-14 18:30 - courts <s>1</s>")
+	case <-time.After(time.Second):
+		t.Fatal("email wasn't sent")
+	}
+}
+
+func TestEmailNotifier_Failed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	e := NewEmailNotifier(addr, "bot@example.com", nil, 10)
+	go e.Run()
+	var delivered error
+	require.NoError(t, e.Notify("bob@example.com", Notification{}, func(sent *SentMessage, err error) {
+		delivered = err
+	}))
+	e.Close()
+	assert.Error(t, delivered)
+}
+
+func TestParseChannels(t *testing.T) {
+	channels, err := ParseChannels("Telegram email bob@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{ChannelTelegram: "", ChannelEmail: "bob@example.com"}, channels)
+
+	channels, err = ParseChannels("EMAIL bob@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{ChannelEmail: "bob@example.com"}, channels)
+
+	for _, input := range []string{"", "email", "email bob", "sms"} {
+		_, err = ParseChannels(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestUserData_channels(t *testing.T) {
+	user := NewUserData("1")
+	assert.Equal(t, map[string]string{ChannelTelegram: "1"}, user.channels())
+	assert.Equal(t, "Telegram", channelsString(user))
+
+	user.Channels = map[string]string{ChannelTelegram: "", ChannelEmail: "bob@example.com"}
+	assert.Equal(t, map[string]string{ChannelTelegram: "1", ChannelEmail: "bob@example.com"}, user.channels())
+	assert.Equal(t, "Telegram\nemail bob@example.com", channelsString(user))
+}
+
+func TestStore_NotifyAll_Channels(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	telegram, email := &fakeNotifier{}, &fakeNotifier{}
+	store.Notifiers = map[string]Notifier{ChannelTelegram: telegram}
+	require.NoError(t, store.Subscribe("1", "Mon 18:00"))
+	channels := map[string]string{ChannelTelegram: "", ChannelEmail: "bob@example.com"}
+	assert.EqualError(t, store.SetChannels("1", channels), "email notifications aren't available")
+
+	store.Notifiers[ChannelEmail] = email
+	require.NoError(t, store.SetChannels("1", channels))
+
+	venue := NewABAVenue().Info()
+	now := time.Now().In(venue.Location)
+	monday := today(now).AddDate(0, 0, 7-(int(now.Weekday())+6)%7).Add(18 * time.Hour)
+	cal := Calendar{monday: {1}, monday.Add(SlotDuration): {1}}
+	telegramSlot := Slot{Venue: venue.ID, Time: monday, Channel: ChannelTelegram}
+	emailSlot := Slot{Venue: venue.ID, Time: monday, Channel: ChannelEmail}
+
+	store.NotifyAll(venue, cal)
+	require.Len(t, telegram.texts, 1)
+	require.Len(t, email.texts, 1)
+	assert.True(t, strings.HasPrefix(telegram.texts[0], "1: New booking available"))
+	assert.True(t, strings.HasPrefix(email.texts[0], "bob@example.com: New booking available"))
+
+	// the failed email doesn't mark slots of email, the delivered telegram notification marks slots of telegram
+	email.deliver(assert.AnError)
+	telegram.deliver(nil)
+	user, _ := store.User("1")
+	assert.True(t, user.isNotified(telegramSlot))
+	assert.False(t, user.isNotified(emailSlot))
+
+	// the email is sent again
+	store.NotifyAll(venue, cal)
+	assert.Len(t, telegram.texts, 1)
+	require.Len(t, email.texts, 2)
+	email.deliver(nil)
+	user, _ = store.User("1")
+	assert.True(t, user.isNotified(emailSlot))
+
+	store.NotifyAll(venue, cal)
+	assert.Len(t, telegram.texts, 1)
+	assert.Len(t, email.texts, 2)
+}
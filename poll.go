@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Callback uniques of "Who's in?" buttons of notifications.
+const (
+	pollIn  = "poll_in"
+	pollOut = "poll_out"
+)
+
+const enoughPlayersText = "Enough players — book now"
+
+// requiredPlayers returns the largest number of players needed by subscriptions matching the calendar, zero if none is set.
+func requiredPlayers(user *UserData, venue string, cal Calendar) int {
+	result := 0
+	for _, sub := range user.Subscriptions {
+		if sub.Players <= result || sub.Paused || sub.VenueID() != venue {
+			continue
+		}
+		if len(cal.ForSubscription(sub)) > 0 {
+			result = sub.Players
+		}
+	}
+	return result
+}
+
+// count returns the number of "I'm in" or "Can't" responses.
+func (m *SentMessage) count(in bool) int {
+	n := 0
+	for _, v := range m.Responses {
+		if v == in {
+			n++
+		}
+	}
+	return n
+}
+
+// respond records the response of the user, it returns true when the number of players is reached for the first time.
+func (m *SentMessage) respond(userID string, in bool) bool {
+	if m.Responses == nil {
+		m.Responses = make(map[string]bool)
+	}
+	m.Responses[userID] = in
+	if m.Enough || m.count(true) < m.Players {
+		return false
+	}
+	m.Enough = true
+	return true
+}
+
+// pollMarkup returns "I'm in" and "Can't" buttons with the tally, nil if the message doesn't need players.
+func (m *SentMessage) pollMarkup() *tele.ReplyMarkup {
+	if m.Players == 0 {
+		return nil
+	}
+	markup := &tele.ReplyMarkup{}
+	markup.Inline(markup.Row(
+		markup.Data(fmt.Sprintf("I'm in %d/%d", m.count(true), m.Players), pollIn),
+		markup.Data(fmt.Sprintf("Can't %d", m.count(false)), pollOut),
+	))
+	return markup
+}
+
+// handlePolls registers callbacks of "Who's in?" buttons, anyone in the chat can respond.
+func handlePolls(b *tele.Bot, store *Store) {
+	respond := func(in bool) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			if c.Message() == nil {
+				return errors.New("poll message is missing")
+			}
+			var enough bool
+			var markup *tele.ReplyMarkup
+			err := store.Update(chatID(c), func(user *UserData) error {
+				for i := range user.Messages {
+					m := &user.Messages[i]
+					if m.MessageID != c.Message().ID {
+						continue
+					}
+					enough = m.respond(strconv.FormatInt(c.Sender().ID, 10), in)
+					markup = m.pollMarkup()
+					return nil
+				}
+				return errors.New("the poll is closed")
+			})
+			if err != nil {
+				return c.Respond(&tele.CallbackResponse{Text: err.Error()})
+			}
+			if err := c.Respond(); err != nil {
+				return err
+			}
+			if err := c.Edit(markup); err != nil {
+				return err
+			}
+			if enough {
+				return c.Reply(enoughPlayersText)
+			}
+			return nil
+		}
+	}
+	b.Handle(&tele.Btn{Unique: pollIn}, respond(true))
+	b.Handle(&tele.Btn{Unique: pollOut}, respond(false))
+}
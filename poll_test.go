@@ -0,0 +1,84 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestParseTimeRange_Players(t *testing.T) {
+	sub, err := ParseTimeRange("Sat 10:00 2 x2 p8")
+	require.NoError(t, err)
+	assert.Equal(t, 8, sub.Players)
+	assert.Equal(t, "Sat 10:00-12:00 x2 p8", sub.String())
+
+	_, err = ParseTimeRange("Sat 10:00 2 p0")
+	assert.Error(t, err)
+	_, err = ParseTimeRange("Sat 10:00 2 px")
+	assert.Error(t, err)
+}
+
+func TestRequiredPlayers(t *testing.T) {
+	start := time.Date(2022, 2, 14, 18, 0, 0, 0, abaLocation)
+	cal := Calendar{start: {1, 2}, start.Add(SlotDuration): {1, 2}}
+	user := NewUserData("-1")
+	user.Subscriptions = []Subscription{
+		{Days: NewWeekdays(time.Monday), Time: Clock{Hour: 18}, Minutes: 60, Players: 4},
+		{Days: NewWeekdays(time.Monday), Time: Clock{Hour: 18}, Minutes: 60, Players: 8, Paused: true},
+		{Days: NewWeekdays(time.Tuesday), Time: Clock{Hour: 18}, Minutes: 60, Players: 6},
+	}
+	assert.Equal(t, 4, requiredPlayers(user, "aba", cal))
+	assert.Equal(t, 0, requiredPlayers(user, "north", cal))
+}
+
+func TestSentMessage_respond(t *testing.T) {
+	m := SentMessage{}
+	assert.Nil(t, m.pollMarkup())
+
+	m.Players = 2
+	assert.False(t, m.respond("1", true))
+	assert.False(t, m.respond("2", false))
+	assert.Equal(t, "I'm in 1/2", m.pollMarkup().InlineKeyboard[0][0].Text)
+	assert.Equal(t, "Can't 1", m.pollMarkup().InlineKeyboard[0][1].Text)
+
+	// changing the answer is counted once
+	assert.True(t, m.respond("2", true))
+	assert.False(t, m.respond("3", true))
+	assert.Equal(t, "I'm in 3/2", m.pollMarkup().InlineKeyboard[0][0].Text)
+}
+
+func TestHandlePolls(t *testing.T) {
+	b, sent := testBot(t)
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	require.NoError(t, store.Update("-1", func(user *UserData) error {
+		user.Messages = []SentMessage{{ChatID: -1, MessageID: 5, Players: 2}}
+		return nil
+	}))
+	handlePolls(b, store)
+
+	group := &tele.Chat{ID: -1, Type: tele.ChatGroup}
+	press := func(userID int64, unique string) {
+		b.ProcessUpdate(tele.Update{Callback: &tele.Callback{
+			ID:      "1",
+			Sender:  &tele.User{ID: userID},
+			Message: &tele.Message{ID: 5, Chat: group},
+			Data:    "\f" + unique,
+		}})
+	}
+	press(1, pollIn)
+	press(2, pollOut)
+	assert.Empty(t, sent["sendMessage"])
+	press(2, pollIn)
+	press(3, pollIn)
+
+	user, _ := store.User("-1")
+	assert.Equal(t, map[string]bool{"1": true, "2": true, "3": true}, user.Messages[0].Responses)
+	assert.True(t, user.Messages[0].Enough)
+	assert.Equal(t, []string{enoughPlayersText}, sent["sendMessage"])
+	assert.Len(t, sent["editMessageReplyMarkup"], 4)
+}
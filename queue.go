@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+var errQueueFull = errors.New("send queue is full")
+
+// messenger sends and edits Telegram messages, it's implemented by tele.Bot.
+type messenger interface {
+	Send(to tele.Recipient, what interface{}, opts ...interface{}) (*tele.Message, error)
+	Edit(msg tele.Editable, what interface{}, opts ...interface{}) (*tele.Message, error)
+}
+
+// OutMessage is a message waiting in the send queue.
+type OutMessage struct {
+	To tele.Recipient
+	// Edit is the message to edit instead of sending a new one to To
+	Edit tele.Editable
+	What interface{}
+	Opts []interface{}
+	// OptsFunc returns the options when the message is sent if it's set, for options which change while it's queued
+	OptsFunc func() []interface{}
+	// Sent is called by the worker after the message is sent
+	Sent func(msg *tele.Message)
+	// Failed is called by the worker when the message couldn't be sent
+	Failed func(err error)
+}
+
+// SendQueue sends messages one by one in a worker, so Telegram rate limits are shared by all senders.
+// Flood errors are retried after the time Telegram asks for, other temporary errors with exponential backoff.
+type SendQueue struct {
+	bot      messenger
+	messages chan OutMessage
+	done     chan struct{}
+	// Attempts is the maximal number of tries of a message
+	Attempts int
+	// Backoff is the delay before the first retry, it doubles with every retry up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	sleep      func(time.Duration)
+}
+
+func NewSendQueue(bot messenger, size int) *SendQueue {
+	return &SendQueue{
+		bot:        bot,
+		messages:   make(chan OutMessage, size),
+		done:       make(chan struct{}),
+		Attempts:   5,
+		Backoff:    time.Second,
+		MaxBackoff: time.Minute,
+		sleep:      time.Sleep,
+	}
+}
+
+// Enqueue adds the message to the queue, it returns false without blocking if the queue is full.
+func (q *SendQueue) Enqueue(m OutMessage) bool {
+	select {
+	case q.messages <- m:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run sends queued messages until the queue is closed.
+func (q *SendQueue) Run() {
+	defer close(q.done)
+	for m := range q.messages {
+		q.deliver(m)
+	}
+}
+
+// Close stops accepting messages and waits until queued messages are sent.
+func (q *SendQueue) Close() {
+	close(q.messages)
+	<-q.done
+}
+
+func (q *SendQueue) deliver(m OutMessage) {
+	for attempt := 1; ; attempt++ {
+		var msg *tele.Message
+		var err error
+		opts := m.Opts
+		if m.OptsFunc != nil {
+			opts = m.OptsFunc()
+		}
+		if m.Edit != nil {
+			msg, err = q.bot.Edit(m.Edit, m.What, opts...)
+		} else {
+			msg, err = q.bot.Send(m.To, m.What, opts...)
+		}
+		if err == nil {
+			if m.Sent != nil {
+				m.Sent(msg)
+			}
+			return
+		}
+		if attempt >= q.Attempts || !temporary(err) {
+			log.Println("could not send message", err)
+			if m.Failed != nil {
+				m.Failed(err)
+			}
+			return
+		}
+		delay := q.retryDelay(err, attempt)
+		log.Printf("could not send message, retrying in %s: %s", delay, err)
+		q.sleep(delay)
+	}
+}
+
+// retryDelay returns the delay before the next attempt, Telegram tells how long to wait on flood errors.
+func (q *SendQueue) retryDelay(err error, attempt int) time.Duration {
+	var flood tele.FloodError
+	if errors.As(err, &flood) {
+		return time.Duration(flood.RetryAfter) * time.Second
+	}
+	delay := q.Backoff << (attempt - 1)
+	if delay > q.MaxBackoff || delay <= 0 {
+		delay = q.MaxBackoff
+	}
+	return delay
+}
+
+// temporary reports whether sending can succeed later, client errors such as a bot blocked by the user are permanent.
+func temporary(err error) bool {
+	var flood tele.FloodError
+	if errors.As(err, &flood) {
+		return true
+	}
+	var apiErr *tele.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code < 400 || apiErr.Code >= 500
+	}
+	return true
+}
+
+// notModified reports whether editing a message failed because the message already has the same content.
+func notModified(err error) bool {
+	if errors.Is(err, tele.ErrMessageNotModified) || errors.Is(err, tele.ErrSameMessageContent) {
+		return true
+	}
+	var apiErr *tele.Error
+	return errors.As(err, &apiErr) && strings.Contains(apiErr.Description, "message is not modified")
+}
+
+// uneditable reports whether editing a message failed because the message can't be edited anymore,
+// for example it was deleted or it's too old.
+func uneditable(err error) bool {
+	if errors.Is(err, tele.ErrCantEditMessage) || errors.Is(err, tele.ErrChatNotFound) {
+		return true
+	}
+	var apiErr *tele.Error
+	return errors.As(err, &apiErr) && strings.Contains(apiErr.Description, "message to edit not found")
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+// fakeMessenger returns errs in order and then sent messages, opts are options of sent messages.
+type fakeMessenger struct {
+	errs  []error
+	calls int
+	sent  []interface{}
+	opts  [][]interface{}
+}
+
+func (f *fakeMessenger) Send(to tele.Recipient, what interface{}, opts ...interface{}) (*tele.Message, error) {
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+	f.sent = append(f.sent, what)
+	f.opts = append(f.opts, opts)
+	return &tele.Message{ID: len(f.sent), Chat: &tele.Chat{ID: 1}}, nil
+}
+
+func (f *fakeMessenger) Edit(msg tele.Editable, what interface{}, opts ...interface{}) (*tele.Message, error) {
+	return f.Send(nil, what, opts...)
+}
+
+func testQueue(bot messenger) (*SendQueue, *[]time.Duration) {
+	var delays []time.Duration
+	q := NewSendQueue(bot, 10)
+	q.sleep = func(d time.Duration) {
+		delays = append(delays, d)
+	}
+	return q, &delays
+}
+
+func TestSendQueue_deliver(t *testing.T) {
+	t.Run("backoff", func(t *testing.T) {
+		bot := &fakeMessenger{errs: []error{errors.New("timeout"), errors.New("timeout")}}
+		q, delays := testQueue(bot)
+		var sent *tele.Message
+		q.deliver(OutMessage{What: "hi", Sent: func(msg *tele.Message) { sent = msg }})
+		require.NotNil(t, sent)
+		assert.Equal(t, []interface{}{"hi"}, bot.sent)
+		assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, *delays)
+	})
+
+	t.Run("attempts", func(t *testing.T) {
+		bot := &fakeMessenger{errs: []error{errors.New("timeout"), errors.New("timeout"), errors.New("timeout")}}
+		q, _ := testQueue(bot)
+		q.Attempts = 2
+		var failed error
+		q.deliver(OutMessage{What: "hi", Failed: func(err error) { failed = err }})
+		assert.Error(t, failed)
+		assert.Equal(t, 2, bot.calls)
+	})
+
+	t.Run("permanent error", func(t *testing.T) {
+		bot := &fakeMessenger{errs: []error{tele.ErrBlockedByUser}}
+		q, delays := testQueue(bot)
+		var failed error
+		q.deliver(OutMessage{What: "hi", Failed: func(err error) { failed = err }})
+		assert.Equal(t, tele.ErrBlockedByUser, failed)
+		assert.Equal(t, 1, bot.calls)
+		assert.Empty(t, *delays)
+	})
+
+	t.Run("retry after", func(t *testing.T) {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				w.Write([]byte(`{"ok": false, "error_code": 429, "description": "Too Many Requests: retry after 7", "parameters": {"retry_after": 7}}`))
+				return
+			}
+			w.Write([]byte(`{"ok": true, "result": {"message_id": 1, "chat": {"id": 1}}}`))
+		}))
+		defer server.Close()
+		b, err := tele.NewBot(tele.Settings{URL: server.URL, Offline: true})
+		require.NoError(t, err)
+
+		q, delays := testQueue(b)
+		var sent bool
+		q.deliver(OutMessage{To: &tele.Chat{ID: 1}, What: "hi", Sent: func(*tele.Message) { sent = true }})
+		assert.True(t, sent)
+		assert.Equal(t, []time.Duration{7 * time.Second}, *delays)
+	})
+}
+
+func TestSendQueue_Enqueue(t *testing.T) {
+	bot := &fakeMessenger{}
+	q := NewSendQueue(bot, 1)
+	assert.True(t, q.Enqueue(OutMessage{What: "1"}))
+	assert.False(t, q.Enqueue(OutMessage{What: "2"}))
+	go q.Run()
+	q.Close()
+	assert.Equal(t, []interface{}{"1"}, bot.sent)
+}
+
+func TestStore_NotifyAll(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	require.NoError(t, store.Subscribe("1", "Mon 18:00"))
+	venue := NewABAVenue().Info()
+	now := time.Now().In(venue.Location)
+	monday := today(now).AddDate(0, 0, 7-(int(now.Weekday())+6)%7).Add(18 * time.Hour)
+	cal := Calendar{monday: {1}, monday.Add(SlotDuration): {1}}
+	slot := Slot{Venue: venue.ID, Time: monday, Channel: ChannelTelegram}
+
+	bot := &fakeMessenger{errs: []error{tele.ErrBlockedByUser}}
+	q := NewSendQueue(bot, 10)
+	store.Notifiers = map[string]Notifier{ChannelTelegram: &TelegramNotifier{Queue: q}}
+	store.NotifyAll(venue, cal)
+	// the notification is queued once until it's sent
+	store.NotifyAll(venue, cal)
+	require.Len(t, q.messages, 1)
+	user, _ := store.User("1")
+	assert.False(t, user.isNotified(slot))
+
+	// failed notifications are queued again
+	q.deliver(<-q.messages)
+	user, _ = store.User("1")
+	assert.False(t, user.isNotified(slot))
+	store.NotifyAll(venue, cal)
+	require.Len(t, q.messages, 1)
+
+	q.deliver(<-q.messages)
+	user, _ = store.User("1")
+	assert.True(t, user.isNotified(slot))
+	require.Len(t, user.Messages, 1)
+	assert.Equal(t, 1, user.Messages[0].MessageID)
+	assert.Equal(t, 1, store.Stats().Sent)
+
+	store.NotifyAll(venue, cal)
+	assert.Empty(t, q.messages)
+}
+
+func TestStore_NotifyAll_UneditableMessage(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	venue := NewABAVenue().Info()
+	slot := today(time.Now().In(venue.Location)).AddDate(0, 0, 1).Add(18 * time.Hour)
+	require.NoError(t, store.Update("1", func(user *UserData) error {
+		user.Messages = []SentMessage{
+			{ChatID: 1, MessageID: 1, Venue: venue.ID, Slots: Calendar{slot: {1}}},
+			{ChatID: 1, MessageID: 2, Venue: venue.ID, Slots: Calendar{slot: {1}}},
+		}
+		return nil
+	}))
+
+	bot := &fakeMessenger{errs: []error{
+		tele.NewError(400, "Bad Request: message to edit not found"),
+		tele.ErrNotStartedByUser,
+	}}
+	store.Queue = NewSendQueue(bot, 10)
+	store.NotifyAll(venue, Calendar{slot: {}})
+	require.Len(t, store.Queue.messages, 2)
+	store.Queue.deliver(<-store.Queue.messages)
+	store.Queue.deliver(<-store.Queue.messages)
+
+	// the deleted message isn't tracked anymore, the other one is edited again later
+	user, _ := store.User("1")
+	require.Len(t, user.Messages, 1)
+	assert.Equal(t, 2, user.Messages[0].MessageID)
+}
+
+func TestStore_NotifyAll_Poll(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	require.NoError(t, store.Subscribe("1", "Mon 18:00 p2"))
+	require.NoError(t, store.Subscribe("-1", "Mon 18:00 p2"))
+	venue := NewABAVenue().Info()
+	now := time.Now().In(venue.Location)
+	monday := today(now).AddDate(0, 0, 7-(int(now.Weekday())+6)%7).Add(18 * time.Hour)
+	cal := Calendar{monday: {1, 2}, monday.Add(SlotDuration): {1, 2}}
+
+	bot := &fakeMessenger{}
+	store.Queue = NewSendQueue(bot, 10)
+	store.Notifiers = map[string]Notifier{ChannelTelegram: &TelegramNotifier{Queue: store.Queue}}
+	store.NotifyAll(venue, cal)
+	require.Len(t, store.Queue.messages, 2)
+	store.Queue.deliver(<-store.Queue.messages)
+	store.Queue.deliver(<-store.Queue.messages)
+	// only the group is asked who's in
+	markups := map[bool]*tele.ReplyMarkup{}
+	for _, id := range []string{"1", "-1"} {
+		user, _ := store.User(id)
+		require.Len(t, user.Messages, 1)
+		markups[id == "-1"] = user.Messages[0].pollMarkup()
+	}
+	assert.Nil(t, markups[false])
+	require.NotNil(t, markups[true])
+
+	// the keyboard of the edit has responses made while the edit was queued
+	store.NotifyAll(venue, Calendar{monday: {2}, monday.Add(SlotDuration): {1, 2}})
+	require.Len(t, store.Queue.messages, 2)
+	for _, id := range []string{"1", "-1"} {
+		require.NoError(t, store.Update(id, func(user *UserData) error {
+			user.Messages[0].respond("5", true)
+			return nil
+		}))
+	}
+	store.Queue.deliver(<-store.Queue.messages)
+	store.Queue.deliver(<-store.Queue.messages)
+	var keyboards []string
+	for _, opts := range bot.opts[2:] {
+		if markup, _ := opts[1].(*tele.ReplyMarkup); markup != nil {
+			keyboards = append(keyboards, markup.InlineKeyboard[0][0].Text)
+		}
+	}
+	assert.Equal(t, []string{"I'm in 1/2"}, keyboards)
+}
+
+func TestStore_NotifyAll_NotModified(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	venue := NewABAVenue().Info()
+	slot := today(time.Now().In(venue.Location)).AddDate(0, 0, 1).Add(18 * time.Hour)
+	require.NoError(t, store.Update("1", func(user *UserData) error {
+		user.Messages = []SentMessage{{ChatID: 1, MessageID: 1, Venue: venue.ID, Slots: Calendar{slot: {1}}}}
+		return nil
+	}))
+
+	bot := &fakeMessenger{errs: []error{tele.ErrSameMessageContent}}
+	store.Queue = NewSendQueue(bot, 10)
+	store.NotifyAll(venue, Calendar{slot: {}})
+	require.Len(t, store.Queue.messages, 1)
+	store.Queue.deliver(<-store.Queue.messages)
+
+	// the message is kept and the edit isn't queued again
+	user, _ := store.User("1")
+	require.Len(t, user.Messages, 1)
+	store.NotifyAll(venue, Calendar{slot: {}})
+	assert.Empty(t, store.Queue.messages)
+}
+
+func TestNotModified(t *testing.T) {
+	assert.True(t, notModified(tele.ErrMessageNotModified))
+	assert.True(t, notModified(tele.NewError(400, "Bad Request: message is not modified: specified new message content")))
+	assert.False(t, notModified(tele.ErrCantEditMessage))
+	assert.False(t, notModified(errors.New("timeout")))
+}
+
+func TestUneditable(t *testing.T) {
+	assert.True(t, uneditable(tele.ErrCantEditMessage))
+	assert.True(t, uneditable(tele.NewError(400, "Bad Request: message to edit not found")))
+	assert.False(t, uneditable(tele.ErrBlockedByUser))
+	assert.False(t, uneditable(errors.New("timeout")))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+func TestCipher(t *testing.T) {
+	c, err := NewCipher(testKey)
+	require.NoError(t, err)
+	secret, err := c.Encrypt([]byte("password"))
+	require.NoError(t, err)
+	plaintext, err := c.Decrypt(secret)
+	require.NoError(t, err)
+	assert.Equal(t, "password", string(plaintext))
+
+	other, err := NewCipher("ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+	require.NoError(t, err)
+	_, err = other.Decrypt(secret)
+	assert.Error(t, err)
+
+	_, err = c.Decrypt("c2hvcnQ=")
+	assert.Error(t, err)
+
+	_, err = NewCipher("c2hvcnQ=")
+	assert.Error(t, err)
+	_, err = NewCipher("not base64")
+	assert.Error(t, err)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	id           TEXT PRIMARY KEY,
+	paused       INTEGER NOT NULL DEFAULT 0,
+	paused_until TEXT NOT NULL DEFAULT '',
+	timezone     TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS subscriptions (
+	user_id  TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	data     TEXT NOT NULL,
+	PRIMARY KEY (user_id, position)
+);
+CREATE TABLE IF NOT EXISTS notified (
+	user_id TEXT NOT NULL,
+	slot    TEXT NOT NULL,
+	PRIMARY KEY (user_id, slot)
+);
+CREATE TABLE IF NOT EXISTS messages (
+	user_id  TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	data     TEXT NOT NULL,
+	PRIMARY KEY (user_id, position)
+);
+CREATE TABLE IF NOT EXISTS credentials (
+	user_id TEXT NOT NULL,
+	venue   TEXT NOT NULL,
+	secret  TEXT NOT NULL,
+	PRIMARY KEY (user_id, venue)
+);
+CREATE TABLE IF NOT EXISTS auto_bookings (
+	user_id  TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	data     TEXT NOT NULL,
+	PRIMARY KEY (user_id, position)
+);
+`
+
+// sqliteMigrations upgrade data of existing databases, sqliteMigrations[i] upgrades user_version i to i+1.
+var sqliteMigrations = []string{
+	// subscriptions saved before subscriptions had IDs
+	`UPDATE subscriptions SET data = json_set(data, '$.id', lower(hex(randomblob(4))))
+		WHERE coalesce(json_extract(data, '$.id'), '') = ''`,
+	// users can be banned and choose notification channels, channels are a JSON object or blank for Telegram only
+	`ALTER TABLE users ADD COLUMN banned INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN channels TEXT NOT NULL DEFAULT ''`,
+}
+
+// SQLiteStorage keeps users in SQLite database, every user is saved in a single transaction.
+// Subscriptions, sent messages, auto-bookings and channels are stored as JSON.
+type SQLiteStorage struct {
+	db *sql.DB
+}
+
+func OpenSQLiteStorage(path string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer anyway
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create schema: %w", err)
+	}
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStorage{db: db}, nil
+}
+
+// migrateSQLite runs migrations which haven't been applied to the database yet.
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("could not migrate database to version %d: %w", version+1, err)
+		}
+		// PRAGMA doesn't support parameters
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) Load() (Data, error) {
+	data := Data{Version: DataVersion, Users: make(map[string]*UserData)}
+	rows, err := s.db.Query(`SELECT id, paused, paused_until, timezone, banned, channels FROM users`)
+	if err != nil {
+		return Data{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		user := NewUserData("")
+		var channels string
+		if err := rows.Scan(&user.ID, &user.Paused, &user.PausedUntil, &user.TimeZone, &user.Banned, &channels); err != nil {
+			return Data{}, err
+		}
+		if channels != "" {
+			if err := json.Unmarshal([]byte(channels), &user.Channels); err != nil {
+				return Data{}, fmt.Errorf("could not load channels of %s: %w", user.ID, err)
+			}
+		}
+		data.Users[user.ID] = user
+	}
+	if err := rows.Err(); err != nil {
+		return Data{}, err
+	}
+
+	err = s.each(`SELECT user_id, data FROM subscriptions ORDER BY user_id, position`, data, func(user *UserData, value string) error {
+		var sub Subscription
+		if err := json.Unmarshal([]byte(value), &sub); err != nil {
+			return err
+		}
+		user.Subscriptions = append(user.Subscriptions, sub)
+		return nil
+	})
+	if err != nil {
+		return Data{}, fmt.Errorf("could not load subscriptions: %w", err)
+	}
+	err = s.each(`SELECT user_id, slot FROM notified`, data, func(user *UserData, value string) error {
+		var slot Slot
+		if err := slot.UnmarshalText([]byte(value)); err != nil {
+			return err
+		}
+		user.addToNotified(slot)
+		return nil
+	})
+	if err != nil {
+		return Data{}, fmt.Errorf("could not load notified slots: %w", err)
+	}
+	err = s.each(`SELECT user_id, data FROM messages ORDER BY user_id, position`, data, func(user *UserData, value string) error {
+		var msg SentMessage
+		if err := json.Unmarshal([]byte(value), &msg); err != nil {
+			return err
+		}
+		user.Messages = append(user.Messages, msg)
+		return nil
+	})
+	if err != nil {
+		return Data{}, fmt.Errorf("could not load messages: %w", err)
+	}
+	// each scans a single value, so venue and secret are packed into a JSON array
+	err = s.each(`SELECT user_id, json_array(venue, secret) FROM credentials`, data, func(user *UserData, value string) error {
+		var fields [2]string
+		if err := json.Unmarshal([]byte(value), &fields); err != nil {
+			return err
+		}
+		if user.Credentials == nil {
+			user.Credentials = make(map[string]string)
+		}
+		user.Credentials[fields[0]] = fields[1]
+		return nil
+	})
+	if err != nil {
+		return Data{}, fmt.Errorf("could not load credentials: %w", err)
+	}
+	err = s.each(`SELECT user_id, data FROM auto_bookings ORDER BY user_id, position`, data, func(user *UserData, value string) error {
+		var booking AutoBooking
+		if err := json.Unmarshal([]byte(value), &booking); err != nil {
+			return err
+		}
+		user.AutoBookings = append(user.AutoBookings, booking)
+		return nil
+	})
+	if err != nil {
+		return Data{}, fmt.Errorf("could not load auto-bookings: %w", err)
+	}
+	return data, nil
+}
+
+// each calls fn for every (user_id, value) row returned by the query, rows of unknown users are skipped.
+func (s *SQLiteStorage) each(query string, data Data, fn func(user *UserData, value string) error) error {
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, value string
+		if err := rows.Scan(&id, &value); err != nil {
+			return err
+		}
+		user, ok := data.Users[id]
+		if !ok {
+			continue
+		}
+		if err := fn(user, value); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLiteStorage) SaveUser(user *UserData) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := deleteUser(tx, user.ID); err != nil {
+		return err
+	}
+	var channels string
+	if len(user.Channels) > 0 {
+		value, err := json.Marshal(user.Channels)
+		if err != nil {
+			return err
+		}
+		channels = string(value)
+	}
+	_, err = tx.Exec(`INSERT INTO users (id, paused, paused_until, timezone, banned, channels) VALUES (?, ?, ?, ?, ?, ?)`,
+		user.ID, user.Paused, user.PausedUntil, user.TimeZone, user.Banned, channels)
+	if err != nil {
+		return err
+	}
+	for i, sub := range user.Subscriptions {
+		value, err := json.Marshal(sub)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO subscriptions (user_id, position, data) VALUES (?, ?, ?)`, user.ID, i, string(value)); err != nil {
+			return err
+		}
+	}
+	for slot := range user.Notified {
+		value, err := slot.MarshalText()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO notified (user_id, slot) VALUES (?, ?)`, user.ID, string(value)); err != nil {
+			return err
+		}
+	}
+	for i, msg := range user.Messages {
+		value, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO messages (user_id, position, data) VALUES (?, ?, ?)`, user.ID, i, string(value)); err != nil {
+			return err
+		}
+	}
+	for venue, secret := range user.Credentials {
+		if _, err := tx.Exec(`INSERT INTO credentials (user_id, venue, secret) VALUES (?, ?, ?)`, user.ID, venue, secret); err != nil {
+			return err
+		}
+	}
+	for i, booking := range user.AutoBookings {
+		value, err := json.Marshal(booking)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO auto_bookings (user_id, position, data) VALUES (?, ?, ?)`, user.ID, i, string(value)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) DeleteUser(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := deleteUser(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func deleteUser(tx *sql.Tx, id string) error {
+	for _, table := range []string{"subscriptions", "notified", "messages", "credentials", "auto_bookings"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE user_id = ?`, id); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id)
+	return err
+}
+
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Storage persists users with their subscriptions and notification state.
+type Storage interface {
+	// Load returns all stored users.
+	Load() (Data, error)
+	// SaveUser replaces the stored user.
+	SaveUser(user *UserData) error
+	DeleteUser(id string) error
+	Close() error
+}
+
+// OpenStorage opens SQLite database for ".db", ".sqlite" and ".sqlite3" files, and JSON file otherwise.
+func OpenStorage(path string) (Storage, error) {
+	switch filepath.Ext(path) {
+	case ".db", ".sqlite", ".sqlite3":
+		return OpenSQLiteStorage(path)
+	default:
+		return OpenJSONStorage(path)
+	}
+}
+
+// JSONStorage keeps all users in a single JSON file which is replaced on every change.
+type JSONStorage struct {
+	mu   sync.Mutex
+	path string
+	data Data
+	// Backups is the number of previous versions of the file kept as "<path>.1", "<path>.2", etc.
+	Backups int
+	// BackupInterval is the minimal time between backups
+	BackupInterval time.Duration
+	lastBackup     time.Time
+}
+
+func OpenJSONStorage(path string) (*JSONStorage, error) {
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	data, err := decodeData(content)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	s := &JSONStorage{
+		path:           path,
+		data:           data,
+		Backups:        3,
+		BackupInterval: time.Hour,
+	}
+	// save migrated data right away, so values set by migrations such as subscription IDs don't change on every start
+	var saved struct {
+		Version int `json:"version"`
+	}
+	if len(bytes.TrimSpace(content)) > 0 && json.Unmarshal(content, &saved) == nil && saved.Version < DataVersion {
+		if err := s.write(time.Now()); err != nil {
+			return nil, fmt.Errorf("could not save migrated data: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// decodeData decodes Data saved in any supported version.
+func decodeData(content []byte) (Data, error) {
+	data := Data{Version: DataVersion}
+	if len(bytes.TrimSpace(content)) > 0 {
+		content, err := migrateData(content)
+		if err != nil {
+			return Data{}, err
+		}
+		if err := json.Unmarshal(content, &data); err != nil {
+			return Data{}, err
+		}
+	}
+	if len(data.Users) == 0 {
+		data.Users = make(map[string]*UserData)
+	}
+	return data, nil
+}
+
+func (s *JSONStorage) Load() (Data, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make(map[string]*UserData, len(s.data.Users))
+	for id, user := range s.data.Users {
+		users[id] = user
+	}
+	return Data{Version: s.data.Version, Users: users}, nil
+}
+
+func (s *JSONStorage) SaveUser(user *UserData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.data.Users[user.ID]
+	s.data.Users[user.ID] = user
+	if err := s.write(time.Now()); err != nil {
+		s.restore(user.ID, old, ok)
+		return err
+	}
+	return nil
+}
+
+func (s *JSONStorage) DeleteUser(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.data.Users[id]
+	delete(s.data.Users, id)
+	if err := s.write(time.Now()); err != nil {
+		s.restore(id, old, ok)
+		return err
+	}
+	return nil
+}
+
+// restore puts back the user replaced by a change which couldn't be written, so the next write doesn't save it.
+func (s *JSONStorage) restore(id string, user *UserData, ok bool) {
+	if ok {
+		s.data.Users[id] = user
+	} else {
+		delete(s.data.Users, id)
+	}
+}
+
+func (s *JSONStorage) Close() error {
+	return nil
+}
+
+func (s *JSONStorage) write(now time.Time) error {
+	s.data.Version = DataVersion
+	content, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if s.Backups > 0 && now.Sub(s.lastBackup) >= s.BackupInterval {
+		if err := s.backup(); err != nil {
+			return fmt.Errorf("could not back up data: %w", err)
+		}
+		s.lastBackup = now
+	}
+	return writeFileAtomic(s.path, append(content, '\n'))
+}
+
+// backup copies the current file to "<path>.1" shifting older backups, the oldest backup is removed.
+func (s *JSONStorage) backup() error {
+	content, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := s.Backups - 1; i > 0; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return writeFileAtomic(s.path+".1", content)
+}
+
+// writeFileAtomic replaces the file with data, the file keeps either old or new content if the process dies.
+// The file keeps its mode, new files are readable by everyone.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// fails harmlessly once the file is renamed
+	defer os.Remove(tmp.Name())
+	// temporary files are created with 0600
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// persist the rename
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// ImportJSON copies all users from the JSON file to the storage and returns the number of imported users.
+func ImportJSON(path string, dst Storage) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	data, err := decodeData(content)
+	if err != nil {
+		return 0, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	for _, user := range data.Users {
+		if err := dst.SaveUser(user); err != nil {
+			return 0, fmt.Errorf("could not import user %s: %w", user.ID, err)
+		}
+	}
+	return len(data.Users), nil
+}
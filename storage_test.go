@@ -0,0 +1,220 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testUser(id string) *UserData {
+	user := NewUserData(id)
+	user.Subscriptions = []Subscription{
+		{Days: NewWeekdays(time.Monday), Time: Clock{Hour: 18}, Minutes: 60},
+		{Date: "2022-02-13", Time: Clock{Hour: 9}, Minutes: 90, Courts: 2, Venue: "aba", Paused: true},
+	}
+	user.addToNotified(Slot{Venue: "aba", Time: time.Date(2022, 2, 13, 9, 0, 0, 0, abaLocation)})
+	user.Paused = true
+	user.PausedUntil = "2022-03-01"
+	user.TimeZone = "Europe/London"
+	user.Messages = []SentMessage{{
+		ChatID:    1,
+		MessageID: 2,
+		Venue:     "aba",
+		Slots:     Calendar{time.Date(2022, 2, 13, 9, 0, 0, 0, time.UTC): {1, 2}},
+		Text:      "text",
+	}}
+	user.Credentials = map[string]string{"aba": "secret"}
+	user.AutoBookings = []AutoBooking{{
+		Subscription: "a",
+		Venue:        "aba",
+		Court:        3,
+		Start:        time.Date(2022, 2, 13, 9, 0, 0, 0, time.UTC),
+		End:          time.Date(2022, 2, 13, 10, 0, 0, 0, time.UTC),
+		Reference:    "B123",
+	}}
+	user.Banned = true
+	user.Channels = map[string]string{ChannelTelegram: "", ChannelEmail: "bob@example.com"}
+	return user
+}
+
+func TestStorage(t *testing.T) {
+	for _, name := range []string{"data.json", "data.db"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			storage, err := OpenStorage(path)
+			require.NoError(t, err)
+			data, err := storage.Load()
+			require.NoError(t, err)
+			assert.Empty(t, data.Users)
+
+			require.NoError(t, storage.SaveUser(testUser("1")))
+			require.NoError(t, storage.SaveUser(testUser("2")))
+			updated := testUser("1")
+			updated.Subscriptions = updated.Subscriptions[:1]
+			updated.Paused = false
+			updated.Banned = false
+			require.NoError(t, storage.SaveUser(updated))
+			require.NoError(t, storage.DeleteUser("2"))
+			require.NoError(t, storage.Close())
+
+			storage, err = OpenStorage(path)
+			require.NoError(t, err)
+			defer storage.Close()
+			data, err = storage.Load()
+			require.NoError(t, err)
+			assert.Equal(t, map[string]*UserData{"1": updated}, data.Users)
+		})
+	}
+}
+
+func TestJSONStorage_Backups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.json")
+	storage, err := OpenJSONStorage(path)
+	require.NoError(t, err)
+	storage.Backups = 2
+	storage.BackupInterval = 0
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		require.NoError(t, storage.SaveUser(NewUserData(id)))
+	}
+	users := func(path string) []string {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		data, err := decodeData(content)
+		require.NoError(t, err)
+		var ids []string
+		for id := range data.Users {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	assert.Equal(t, []string{"1", "2", "3", "4"}, users(path))
+	assert.Equal(t, []string{"1", "2", "3"}, users(path+".1"))
+	assert.Equal(t, []string{"1", "2"}, users(path+".2"))
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 3, "no temporary files are left")
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"version": 2`)
+}
+
+func TestWriteFileAtomic_Mode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	require.NoError(t, writeFileAtomic(path, []byte("1")))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	// the mode of the existing file is kept
+	require.NoError(t, os.Chmod(path, 0640))
+	require.NoError(t, writeFileAtomic(path, []byte("2")))
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(content))
+}
+
+func TestJSONStorage_FailedWrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, os.Mkdir(dir, 0700))
+	path := filepath.Join(dir, "data.json")
+	store, err := NewStore(path)
+	require.NoError(t, err)
+	require.NoError(t, store.Subscribe("1", "Mon 18:00"))
+
+	// writes fail while the directory is missing
+	require.NoError(t, os.Rename(dir, dir+".moved"))
+	assert.Error(t, store.Subscribe("1", "Tue 18:00"))
+	assert.Error(t, store.Subscribe("2", "Tue 18:00"))
+	assert.Error(t, store.DeleteUser("1"))
+	require.NoError(t, os.Rename(dir+".moved", dir))
+
+	// rejected changes aren't saved by the next write
+	require.NoError(t, store.Subscribe("3", "Wed 18:00"))
+	store, err = NewStore(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Mon 18:00-19:00", store.Subscriptions("1"))
+	assert.Equal(t, "", store.Subscriptions("2"))
+	assert.Equal(t, "Wed 18:00-19:00", store.Subscriptions("3"))
+}
+
+func TestSQLiteStorage_Migrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+	// a database of version 0 with a subscription saved before subscriptions had IDs
+	db, err := sql.Open("sqlite", "file:"+path)
+	require.NoError(t, err)
+	_, err = db.Exec(sqliteSchema)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO users (id) VALUES ('1');
+		INSERT INTO subscriptions (user_id, position, data) VALUES ('1', 0, '{"days": 2, "minutes": 60}')`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		storage, err := OpenSQLiteStorage(path)
+		require.NoError(t, err)
+		data, err := storage.Load()
+		require.NoError(t, err)
+		require.Len(t, data.Users["1"].Subscriptions, 1)
+		ids = append(ids, data.Users["1"].Subscriptions[0].ID)
+		assert.False(t, data.Users["1"].Banned)
+		assert.Nil(t, data.Users["1"].Channels)
+		require.NoError(t, storage.Close())
+	}
+	assert.Len(t, ids[0], 8)
+	assert.Equal(t, ids[0], ids[1], "migrations run once")
+
+	// columns added by migrations are saved
+	storage, err := OpenSQLiteStorage(path)
+	require.NoError(t, err)
+	defer storage.Close()
+	user := NewUserData("1")
+	user.Banned = true
+	user.Channels = map[string]string{ChannelEmail: "bob@example.com"}
+	require.NoError(t, storage.SaveUser(user))
+	data, err := storage.Load()
+	require.NoError(t, err)
+	assert.True(t, data.Users["1"].Banned)
+	assert.Equal(t, map[string]string{ChannelEmail: "bob@example.com"}, data.Users["1"].Channels)
+}
+
+func TestImportJSON(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "data.json")
+	require.NoError(t, os.WriteFile(src, []byte(`{"users": {
+		"1": {"id": "1", "subscriptions": [{"weekday": 1, "time": {"hours": 18}, "hours": 1}], "notified": {"2022-02-13T09:00:00+13:00": {}}}
+	}}`), 0600))
+	dst, err := OpenSQLiteStorage(filepath.Join(dir, "data.db"))
+	require.NoError(t, err)
+	defer dst.Close()
+
+	n, err := ImportJSON(src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	data, err := dst.Load()
+	require.NoError(t, err)
+	require.Contains(t, data.Users, "1")
+	user := data.Users["1"]
+	require.Len(t, user.Subscriptions, 1)
+	assert.NotEmpty(t, user.Subscriptions[0].ID)
+	user.Subscriptions[0].ID = ""
+	assert.Equal(t, []Subscription{{Days: NewWeekdays(time.Monday), Time: Clock{Hour: 18}, Minutes: 60}}, user.Subscriptions)
+	assert.True(t, user.isNotified(Slot{Venue: "aba", Time: time.Date(2022, 2, 13, 9, 0, 0, 0, abaLocation)}))
+
+	_, err = ImportJSON(filepath.Join(dir, "missing.json"), dst)
+	assert.Error(t, err)
+}
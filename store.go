@@ -2,14 +2,16 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"gopkg.in/telebot.v3"
-	"io"
+	"html"
 	"log"
-	"net/http"
-	"os"
+	"math"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
@@ -19,75 +21,593 @@ import (
 
 type Store struct {
 	sync.RWMutex
-	Data Data
-	File *os.File
+	Data    Data
+	Storage Storage
+	// Cipher encrypts venue credentials, auto-booking is disabled if it's nil
+	Cipher *Cipher
+	// sent and lastFetch are counted since start for /stats, they're guarded by the store lock
+	sent      int
+	lastFetch time.Time
+	// pending are keys of queued messages, so they aren't queued again before they're sent
+	pending map[string]bool
+	// unsavedBookings are auto-bookings which couldn't be saved by user ID, saving is retried with the next check
+	unsavedBookings map[string][]unsavedBooking
+	// Notifiers deliver notifications by channel name
+	Notifiers map[string]Notifier
+	// Queue sends edits of Telegram notifications
+	Queue *SendQueue
 }
 
 type Data struct {
-	Users map[string]*UserData `json:"users"`
+	// Version is the format version of saved data, see migrations
+	Version int                  `json:"version"`
+	Users   map[string]*UserData `json:"users"`
 }
 
+// UserData is the data of a chat, a private chat with a user or a group.
 type UserData struct {
-	ID            string                 `json:"id"`
-	Subscriptions []Subscription         `json:"subscriptions"`
-	Notified      map[time.Time]struct{} `json:"notified"`
+	// ID is the Telegram chat ID, it's the user ID in private chats and negative for groups
+	ID            string            `json:"id"`
+	Subscriptions []Subscription    `json:"subscriptions"`
+	Notified      map[Slot]struct{} `json:"notified"`
+	// Paused stops all notifications until PausedUntil date, or until resumed if PausedUntil is blank
+	Paused      bool   `json:"paused,omitempty"`
+	PausedUntil string `json:"paused_until,omitempty"`
+	// TimeZone is IANA name of the timezone used to display times
+	TimeZone string `json:"timezone,omitempty"`
+	// Messages are sent notifications which are updated when the slots are booked
+	Messages []SentMessage `json:"messages,omitempty"`
+	// Credentials are venue logins by venue ID encrypted with Cipher
+	Credentials map[string]string `json:"credentials,omitempty"`
+	// AutoBookings are upcoming bookings made on behalf of the user
+	AutoBookings []AutoBooking `json:"auto_bookings,omitempty"`
+	// Banned users and groups are ignored by the bot
+	Banned bool `json:"banned,omitempty"`
+	// Channels are notification channels with addresses, notifications are sent to the chat if it's empty
+	Channels map[string]string `json:"channels,omitempty"`
 }
 
-func (u *UserData) addToNotified(t time.Time) {
+// channels returns addresses of the notification channels of the user, the address of the Telegram channel is the chat ID.
+func (u *UserData) channels() map[string]string {
+	if len(u.Channels) == 0 {
+		return map[string]string{ChannelTelegram: u.ID}
+	}
+	result := make(map[string]string, len(u.Channels))
+	for channel, address := range u.Channels {
+		if channel == ChannelTelegram {
+			address = u.ID
+		}
+		result[channel] = address
+	}
+	return result
+}
+
+// IsPaused reports whether notifications are paused on the day of now.
+func (u *UserData) IsPaused(now time.Time) bool {
+	return u.Paused && (u.PausedUntil == "" || now.In(u.Location()).Format(dateLayout) < u.PausedUntil)
+}
+
+// Location returns the user timezone, times are displayed in the default venue timezone if the user hasn't set one.
+func (u *UserData) Location() *time.Location {
+	if u.TimeZone != "" {
+		if loc, err := time.LoadLocation(u.TimeZone); err == nil {
+			return loc
+		}
+	}
+	if v, ok := Venues.Get(""); ok {
+		return v.Info().Location
+	}
+	return time.Local
+}
+
+func (u *UserData) addToNotified(slot Slot) {
 	if u.Notified == nil {
-		u.Notified = make(map[time.Time]struct{})
+		u.Notified = make(map[Slot]struct{})
+	}
+	u.Notified[slot.UTC()] = struct{}{}
+}
+
+// isNotified reports whether the user was notified about the slot over the channel of the slot.
+// Slots with a blank channel count for all channels.
+func (u *UserData) isNotified(slot Slot) bool {
+	slot = slot.UTC()
+	if _, ok := u.Notified[slot]; ok {
+		return true
+	}
+	slot.Channel = ""
+	_, ok := u.Notified[slot]
+	return ok
+}
+
+// notifiedOverAny reports whether the user was notified about the slot over any of the channels.
+func (u *UserData) notifiedOverAny(slot Slot) bool {
+	for channel := range u.channels() {
+		slot.Channel = channel
+		if u.isNotified(slot) {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetNotified removes notified slots of the venue which are in cal but don't match user subscriptions anymore,
+// so they are announced again once they free up, and slots which are in the past.
+func (u *UserData) forgetNotified(venue VenueInfo, cal, matches Calendar, now time.Time) {
+	for slot := range u.Notified {
+		if !slot.Time.Add(SlotDuration).After(now) {
+			delete(u.Notified, slot)
+			continue
+		}
+		if slot.Venue != venue.ID {
+			continue
+		}
+		t := slot.Time.In(venue.Location)
+		if _, ok := cal[t]; !ok {
+			continue
+		}
+		if _, ok := matches[t]; !ok {
+			delete(u.Notified, slot)
+		}
 	}
-	u.Notified[t] = struct{}{}
+}
+
+// pruneExpired forgets notified slots and sent messages which are in the past,
+// it runs for paused and banned users too so their data doesn't grow.
+func (u *UserData) pruneExpired(now time.Time) {
+	for slot := range u.Notified {
+		if !slot.Time.Add(SlotDuration).After(now) {
+			delete(u.Notified, slot)
+		}
+	}
+	kept := u.Messages[:0]
+	for _, m := range u.Messages {
+		if !m.expired(now) {
+			kept = append(kept, m)
+		}
+	}
+	u.Messages = kept
 }
 
 func NewUserData(id string) *UserData {
 	return &UserData{
 		ID:            id,
 		Subscriptions: make([]Subscription, 0),
-		Notified:      make(map[time.Time]struct{}),
+		Notified:      make(map[Slot]struct{}),
 	}
 }
 
+// clone returns a deep copy of the user.
+func (u *UserData) clone() *UserData {
+	c := *u
+	if u.Subscriptions != nil {
+		c.Subscriptions = append(make([]Subscription, 0, len(u.Subscriptions)), u.Subscriptions...)
+	}
+	if u.Notified != nil {
+		c.Notified = make(map[Slot]struct{}, len(u.Notified))
+		for slot := range u.Notified {
+			c.Notified[slot] = struct{}{}
+		}
+	}
+	if u.Credentials != nil {
+		c.Credentials = make(map[string]string, len(u.Credentials))
+		for venue, secret := range u.Credentials {
+			c.Credentials[venue] = secret
+		}
+	}
+	if u.Channels != nil {
+		c.Channels = make(map[string]string, len(u.Channels))
+		for channel, address := range u.Channels {
+			c.Channels[channel] = address
+		}
+	}
+	if u.AutoBookings != nil {
+		c.AutoBookings = append(make([]AutoBooking, 0, len(u.AutoBookings)), u.AutoBookings...)
+	}
+	if u.Messages != nil {
+		c.Messages = make([]SentMessage, len(u.Messages))
+		for i, m := range u.Messages {
+			m.Slots = m.Slots.clone()
+			if m.Responses != nil {
+				responses := make(map[string]bool, len(m.Responses))
+				for id, in := range m.Responses {
+					responses[id] = in
+				}
+				m.Responses = responses
+			}
+			c.Messages[i] = m
+		}
+	}
+	return &c
+}
+
+// Slot identifies a slot of a venue.
+type Slot struct {
+	Venue string
+	Time  time.Time
+	// Channel is the channel a notified slot was sent over, blank for all channels
+	Channel string
+}
+
+// UTC returns the slot with time in UTC, slots are stored in UTC so they can be used as map keys.
+func (s Slot) UTC() Slot {
+	s.Time = s.Time.UTC()
+	return s
+}
+
+// MarshalText encodes slot as "venue@time" or "venue@time@channel", the format is used for JSON map keys.
+func (s Slot) MarshalText() ([]byte, error) {
+	t, err := s.Time.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	text := s.Venue + "@" + string(t)
+	if s.Channel != "" {
+		text += "@" + s.Channel
+	}
+	return []byte(text), nil
+}
+
+// UnmarshalText decodes "venue@time" or "venue@time@channel".
+func (s *Slot) UnmarshalText(data []byte) error {
+	parts := strings.Split(string(data), "@")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("incorrect slot: %q", data)
+	}
+	s.Venue = parts[0]
+	if err := s.Time.UnmarshalText([]byte(parts[1])); err != nil {
+		return err
+	}
+	s.Time = s.Time.UTC()
+	s.Channel = ""
+	if len(parts) == 3 {
+		s.Channel = parts[2]
+	}
+	return nil
+}
+
 type Subscription struct {
-	Weekday time.Weekday `json:"weekday"`
-	Time    Clock        `json:"time"`
-	Hours   int          `json:"hours"`
+	// ID identifies the subscription in buttons, it doesn't change when the subscription is edited
+	ID   string   `json:"id,omitempty"`
+	Days Weekdays `json:"days"`
+	Time Clock    `json:"time"`
+	// Minutes is the duration of the booking
+	Minutes int `json:"minutes"`
+	// End is the latest end time of a window subscription, zero for subscriptions with a fixed start time
+	End Clock `json:"end"`
+	// Courts is the number of courts required at the same time, zero means a single court
+	Courts int `json:"courts,omitempty"`
+	// CourtSwitch allows moving to a different court in the middle of the booking
+	CourtSwitch bool `json:"court_switch,omitempty"`
+	// Venue is the venue ID, blank for the default venue
+	Venue string `json:"venue,omitempty"`
+	// Date of a one-off subscription in "2006-01-02" format, Days are ignored if it's set
+	Date string `json:"date,omitempty"`
+	// Paused subscriptions don't send notifications
+	Paused bool `json:"paused,omitempty"`
+	// AutoBook books matching courts using the user's venue credentials
+	AutoBook bool `json:"auto_book,omitempty"`
+	// Players is the number of players needed, notifications ask who's in when it's set
+	Players int `json:"players,omitempty"`
+}
+
+// sameAs compares subscriptions ignoring their ID and state.
+func (r Subscription) sameAs(other Subscription) bool {
+	r.ID, other.ID = "", ""
+	r.Paused, other.Paused = false, false
+	return r == other
+}
+
+func newSubscriptionID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+const dateLayout = "2006-01-02"
+
+func today(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
 }
 
+const subscriptionFormat = `expected format: "Mon 15:00 2", "Tue 18:30 1.5 x3 switch", "Mon-Fri 17:00-21:00", "Weekend,Wed 10:00", "Weekday 17:00-22:00 2", "Sat 10:00 2 @venue" or "2026-11-07 10:00 2"`
+
+// ParseTimeRange parses "<days|date> <start>[-<end>] [hours] [xCourts] [switch] [book] [pPlayers] [@venue]".
+// When both end time and hours are given the subscription matches any block of hours between start and end.
+// Days is a comma separated list of days ("Mon"), ranges ("Mon-Fri") and groups ("Weekday", "Weekend", "Daily"),
+// or a date ("2026-11-07") for a one-off subscription.
+// Times and durations must be multiples of SlotDuration.
 func ParseTimeRange(input string) (Subscription, error) {
-	data := strings.Split(strings.ToLower(strings.TrimSpace(input)), " ")
+	data := strings.Fields(strings.ToLower(input))
+	data, opts, err := parseOptions(data, 2, subscriptionOptions...)
+	if err != nil {
+		return Subscription{}, err
+	}
+	courts, courtSwitch, venue := opts.courts, opts.courtSwitch, opts.venue
 	if len(data) < 2 || len(data) > 3 {
-		return Subscription{}, errors.New(`incorrect time format, expected format: "Mon 15:00 2"`)
+		return Subscription{}, fmt.Errorf("incorrect time format, %s", subscriptionFormat)
 	}
-	weekday, ok := weekdayMapping[data[0]]
-	if !ok {
-		return Subscription{}, fmt.Errorf("unknown day of the week: %s", weekday)
+	var days Weekdays
+	if _, err := time.Parse(dateLayout, data[0]); err != nil {
+		days, err = ParseWeekdays(data[0])
+		if err != nil {
+			return Subscription{}, err
+		}
 	}
 
-	startClock, err := parseTime(data[1])
+	clocks := strings.Split(data[1], "-")
+	if len(clocks) > 2 {
+		return Subscription{}, fmt.Errorf("incorrect time range: \"%s\"", data[1])
+	}
+	startClock, err := parseSlotTime(clocks[0])
 	if err != nil {
 		return Subscription{}, err
 	}
-	hours := 1
+	minutes := int(MinBookingDuration / time.Minute)
+	var endClock Clock
+	if len(clocks) == 2 {
+		endClock, err = parseSlotTime(clocks[1])
+		if err != nil {
+			return Subscription{}, err
+		}
+		minutes = endClock.Minutes() - startClock.Minutes()
+		if minutes <= 0 {
+			return Subscription{}, errors.New("end time must be after start time")
+		}
+	}
+	window := 0
 	if len(data) >= 3 {
-		hours, err = strconv.Atoi(data[2])
+		window = minutes
+		minutes, err = parseHours(data[2])
 		if err != nil {
 			return Subscription{}, err
 		}
 	}
-	if hours < 1 {
-		return Subscription{}, errors.New("hours must be equal or greater than 1")
+	if minutes < int(MinBookingDuration/time.Minute) {
+		return Subscription{}, fmt.Errorf("booking can't be shorter than %s", formatMinutes(int(MinBookingDuration/time.Minute)))
+	}
+	// calendars are looked up by the day of the start, so bookings can't run past midnight
+	if startClock.Minutes()+minutes > 24*60 {
+		return Subscription{}, errors.New("booking must be within a day")
 	}
 
-	return Subscription{
-		Weekday: weekday,
+	sub := Subscription{
+		Days:    days,
 		Time:    startClock,
-		Hours:   hours,
-	}, nil
+		Minutes: minutes,
+	}
+	if days == 0 {
+		sub.Date = data[0]
+	}
+	if courts > 1 {
+		sub.Courts = courts
+	}
+	if courtSwitch && time.Duration(minutes)*time.Minute > SlotDuration {
+		sub.CourtSwitch = true
+	}
+	sub.Venue = venue
+	sub.AutoBook = opts.autoBook
+	sub.Players = opts.players
+	if sub.Expired(time.Now()) {
+		return Subscription{}, fmt.Errorf("date %s is in the past", sub.Date)
+	}
+	// "17:00-22:00 2" is any 2 consecutive hours between 17:00 and 22:00
+	if len(clocks) == 2 && len(data) >= 3 {
+		if minutes > window {
+			return Subscription{}, fmt.Errorf("%s doesn't fit between %s and %s", formatMinutes(minutes), clocks[0], clocks[1])
+		}
+		if minutes < window {
+			sub.End = endClock
+		}
+	}
+	return sub, nil
+}
+
+// options are trailing "[xN] [switch] [book] [pN] [@venue]" options of commands.
+type options struct {
+	courts      int
+	courtSwitch bool
+	autoBook    bool
+	players     int
+	// venue is blank for the default venue
+	venue string
+}
+
+// Option names accepted by parseOptions.
+const (
+	optionCourts  = "x"
+	optionSwitch  = "switch"
+	optionBook    = "book"
+	optionPlayers = "p"
+	optionVenue   = "@"
+)
+
+// subscriptionOptions are options of subscriptions, other commands support some of them.
+var subscriptionOptions = []string{optionCourts, optionSwitch, optionBook, optionPlayers, optionVenue}
+
+// optionName returns the name of the option, blank if the field isn't an option.
+func optionName(option string) string {
+	switch {
+	case option == optionSwitch || option == optionBook:
+		return option
+	case strings.HasPrefix(option, optionVenue):
+		return optionVenue
+	case strings.HasPrefix(option, optionCourts):
+		return optionCourts
+	case len(option) > 1 && option[0] == 'p' && option[1] >= '0' && option[1] <= '9':
+		return optionPlayers
+	}
+	return ""
+}
+
+// parseOptions parses options from the end of fields keeping at least min fields, the rest of fields is returned.
+// allowed are names of options supported by the command, other options are rejected.
+func parseOptions(data []string, min int, allowed ...string) ([]string, options, error) {
+	opts := options{courts: 1}
+	for len(data) > min {
+		option := data[len(data)-1]
+		name := optionName(option)
+		if name == "" {
+			break
+		}
+		supported := false
+		for _, a := range allowed {
+			supported = supported || a == name
+		}
+		if !supported {
+			return nil, options{}, fmt.Errorf("option \"%s\" isn't supported here", option)
+		}
+		switch name {
+		case optionSwitch:
+			opts.courtSwitch = true
+		case optionBook:
+			opts.autoBook = true
+		case optionVenue:
+			v, ok := Venues.Get(option[1:])
+			if !ok || option == "@" {
+				return nil, options{}, fmt.Errorf("unknown venue: %s, available venues:\n%s", option, Venues)
+			}
+			if v.Info().ID != Venues.DefaultID() {
+				opts.venue = v.Info().ID
+			}
+		case optionCourts:
+			var err error
+			opts.courts, err = strconv.Atoi(option[1:])
+			if err != nil {
+				return nil, options{}, fmt.Errorf("incorrect number of courts: \"%s\"", option)
+			}
+			if opts.courts < 1 {
+				return nil, options{}, errors.New("number of courts must be equal or greater than 1")
+			}
+		case optionPlayers:
+			var err error
+			opts.players, err = strconv.Atoi(option[1:])
+			if err != nil || opts.players < 1 {
+				return nil, options{}, fmt.Errorf("incorrect number of players: \"%s\"", option)
+			}
+		}
+		data = data[:len(data)-1]
+	}
+	return data, opts, nil
+}
+
+// parseHours parses a duration in hours, e.g. "2" or "1.5", and returns it in minutes.
+func parseHours(input string) (int, error) {
+	hours, err := strconv.ParseFloat(input, 64)
+	if err != nil {
+		return 0, fmt.Errorf("incorrect number of hours: \"%s\"", input)
+	}
+	minutes := int(math.Round(hours * 60))
+	if time.Duration(minutes)*time.Minute%SlotDuration != 0 {
+		return 0, fmt.Errorf("duration must be a multiple of %s", formatMinutes(int(SlotDuration/time.Minute)))
+	}
+	return minutes, nil
+}
+
+func formatMinutes(minutes int) string {
+	return strconv.FormatFloat(float64(minutes)/60, 'f', -1, 64) + "h"
+}
+
+// validate checks subscriptions which are changed without parsing.
+func (r *Subscription) validate() error {
+	step := int(SlotDuration / time.Minute)
+	if r.Time.Minutes()%step != 0 || r.Minutes%step != 0 {
+		return fmt.Errorf("time must be a multiple of %s", formatMinutes(step))
+	}
+	if r.Minutes < int(MinBookingDuration/time.Minute) {
+		return fmt.Errorf("booking can't be shorter than %s", formatMinutes(int(MinBookingDuration/time.Minute)))
+	}
+	end := r.Time.Minutes() + r.Minutes
+	if r.IsWindow() {
+		if end > r.End.Minutes() {
+			return fmt.Errorf("%s doesn't fit between %s and %s", formatMinutes(r.Minutes), r.Time, r.End)
+		}
+		end = r.End.Minutes()
+	}
+	if r.Time.Minutes() < 0 || end > 24*60 {
+		return errors.New("booking must be within a day")
+	}
+	return nil
+}
+
+// IsWindow reports whether the subscription may start at any time between Time and End.
+func (r *Subscription) IsWindow() bool {
+	return r.End != Clock{}
+}
+
+// MinCourts returns the number of courts which must be available at the same time.
+func (r *Subscription) MinCourts() uint {
+	if r.Courts < 1 {
+		return 1
+	}
+	return uint(r.Courts)
+}
+
+func (r *Subscription) Duration() time.Duration {
+	return time.Duration(r.Minutes) * time.Minute
 }
 
 func (r *Subscription) String() string {
-	return fmt.Sprintf("%s %02d:%02d-%02d:%02d", r.Weekday.String()[:3], r.Time.Hour, r.Time.Minute, r.Time.Hour+r.Hours, r.Time.Minute)
+	var s string
+	if r.IsWindow() {
+		// the same "17:00-22:00 2" syntax as ParseTimeRange, so the line can be copied into commands
+		s = fmt.Sprintf("%s %s-%s %s", r.day(), r.Time, r.End, strings.TrimSuffix(formatMinutes(r.Minutes), "h"))
+	} else {
+		s = fmt.Sprintf("%s %s-%s", r.day(), r.Time, r.Time.Add(r.Minutes))
+	}
+	if r.Courts > 1 {
+		s += fmt.Sprintf(" x%d", r.Courts)
+	}
+	if r.CourtSwitch {
+		s += " switch"
+	}
+	if r.AutoBook {
+		s += " book"
+	}
+	if r.Players > 0 {
+		s += fmt.Sprintf(" p%d", r.Players)
+	}
+	if r.Venue != "" {
+		s += " @" + r.Venue
+	}
+	return s
+}
+
+func (r *Subscription) day() string {
+	if r.Date != "" {
+		return r.Date
+	}
+	return r.Days.String()
+}
+
+// MatchesDay reports whether the subscription is for the day of t.
+func (r *Subscription) MatchesDay(t time.Time) bool {
+	if r.Date != "" {
+		return t.Format(dateLayout) == r.Date
+	}
+	return r.Days.Has(t.Weekday())
+}
+
+// Expired reports whether the subscription is for a date before the day of now in the venue timezone.
+func (r *Subscription) Expired(now time.Time) bool {
+	return r.Date != "" && r.Date < now.In(r.Location()).Format(dateLayout)
+}
+
+// Location returns timezone of the subscription venue.
+func (r *Subscription) Location() *time.Location {
+	if v, ok := Venues.Get(r.VenueID()); ok {
+		return v.Info().Location
+	}
+	return time.Local
+}
+
+// VenueID returns ID of the subscription venue.
+func (r *Subscription) VenueID() string {
+	if r.Venue == "" {
+		return Venues.DefaultID()
+	}
+	return r.Venue
 }
 
 type Clock struct {
@@ -95,45 +615,82 @@ type Clock struct {
 	Minute int `json:"minutes"`
 }
 
+// Minutes returns the number of minutes since midnight.
+func (c Clock) Minutes() int {
+	return c.Hour*60 + c.Minute
+}
+
+func (c Clock) Add(minutes int) Clock {
+	m := c.Minutes() + minutes
+	return Clock{Hour: m / 60, Minute: m % 60}
+}
+
+func (c Clock) String() string {
+	return fmt.Sprintf("%02d:%02d", c.Hour, c.Minute)
+}
+
+func clockOf(t time.Time) Clock {
+	return Clock{Hour: t.Hour(), Minute: t.Minute()}
+}
+
+// NewStore opens the storage at path, see OpenStorage.
 func NewStore(path string) (*Store, error) {
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
-	var data Data
-	err = json.NewDecoder(file).Decode(&data)
-	if err != nil && !errors.Is(err, io.EOF) {
+	storage, err := OpenStorage(path)
+	if err != nil {
 		return nil, err
 	}
-	if len(data.Users) == 0 {
-		data.Users = make(map[string]*UserData)
+	data, err := storage.Load()
+	if err != nil {
+		storage.Close()
+		return nil, err
 	}
 	return &Store{
-		File: file,
-		Data: data,
+		Storage: storage,
+		Data:    data,
 	}, nil
 }
 
-func (s *Store) addTime(userID string, time Subscription) {
-	user, ok := s.Data.Users[userID]
-	if !ok {
-		user = NewUserData(userID)
-		s.Data.Users[userID] = user
-	}
-	// avoid duplicates
-	for _, t := range user.Subscriptions {
-		if t == time {
+func (s *Store) Close() error {
+	return s.Storage.Close()
+}
+
+// addSubscription adds the subscription unless the same subscription exists.
+func (u *UserData) addSubscription(sub Subscription) {
+	for _, t := range u.Subscriptions {
+		if t.sameAs(sub) {
 			return
 		}
 	}
-	user.Subscriptions = append(user.Subscriptions, time)
+	if sub.ID == "" {
+		sub.ID = newSubscriptionID()
+	}
+	u.Subscriptions = append(u.Subscriptions, sub)
 }
 
-func (s *Store) removeTime(userID string, time Subscription) error {
-	user, ok := s.Data.Users[userID]
-	if !ok {
-		return errors.New("user not found")
+// subscription returns index of the subscription with the ID.
+func (u *UserData) subscription(id string) (int, error) {
+	for i, sub := range u.Subscriptions {
+		if sub.ID == id {
+			return i, nil
+		}
+	}
+	return 0, errors.New("subscription not found")
+}
+
+func (u *UserData) removeSubscription(sub Subscription) error {
+	for i, t := range u.Subscriptions {
+		if t.sameAs(sub) {
+			u.Subscriptions = append(u.Subscriptions[:i], u.Subscriptions[i+1:]...)
+			return nil
+		}
 	}
-	for i, t := range user.Subscriptions {
-		if t == time {
-			user.Subscriptions = append(user.Subscriptions[:i], user.Subscriptions[i+1:]...)
+	return errors.New("time not found")
+}
+
+func (u *UserData) pauseSubscription(sub Subscription, paused bool) error {
+	for i, t := range u.Subscriptions {
+		if t.sameAs(sub) {
+			u.Subscriptions[i].Paused = paused
 			return nil
 		}
 	}
@@ -157,54 +714,484 @@ var weekdayMapping = map[string]time.Weekday{
 	"sunday":    time.Sunday,
 }
 
-// time format example "Mon 15:00"
-// TODO: "Weekday 15:00"
-// TODO: "Weekend 15:00"
-// TODO: "Mon-Tue 15:00-17:00"
+// Weekdays is a set of days of the week, bit N is set for time.Weekday(N).
+type Weekdays uint8
+
+const (
+	Weekend     = Weekdays(1<<time.Saturday | 1<<time.Sunday)
+	WorkingDays = Weekdays(1<<time.Monday | 1<<time.Tuesday | 1<<time.Wednesday | 1<<time.Thursday | 1<<time.Friday)
+	AllDays     = Weekend | WorkingDays
+)
+
+var weekdayGroups = map[string]Weekdays{
+	"weekday":  WorkingDays,
+	"weekdays": WorkingDays,
+	"weekend":  Weekend,
+	"weekends": Weekend,
+	"daily":    AllDays,
+	"everyday": AllDays,
+}
+
+// week order used for displaying days, the week starts on Monday
+var weekOrder = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+
+func NewWeekdays(days ...time.Weekday) Weekdays {
+	var w Weekdays
+	for _, d := range days {
+		w |= 1 << d
+	}
+	return w
+}
+
+func (w Weekdays) Has(day time.Weekday) bool {
+	return w&(1<<day) != 0
+}
+
+// ParseWeekdays parses a comma separated list of days, day ranges and day groups, e.g. "mon-wed,fri,weekend".
+func ParseWeekdays(input string) (Weekdays, error) {
+	var result Weekdays
+	for _, item := range strings.Split(strings.ToLower(input), ",") {
+		if group, ok := weekdayGroups[item]; ok {
+			result |= group
+			continue
+		}
+		bounds := strings.Split(item, "-")
+		if len(bounds) > 2 {
+			return 0, fmt.Errorf("incorrect day range: %s", item)
+		}
+		from, ok := weekdayMapping[bounds[0]]
+		if !ok {
+			return 0, fmt.Errorf("unknown day of the week: %s", bounds[0])
+		}
+		to := from
+		if len(bounds) == 2 {
+			to, ok = weekdayMapping[bounds[1]]
+			if !ok {
+				return 0, fmt.Errorf("unknown day of the week: %s", bounds[1])
+			}
+		}
+		// ranges may wrap around the end of the week, e.g. "sat-mon"
+		for d := from; ; d = (d + 1) % 7 {
+			result |= 1 << d
+			if d == to {
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+func (w Weekdays) String() string {
+	switch w {
+	case AllDays:
+		return "Daily"
+	case WorkingDays:
+		return "Weekdays"
+	case Weekend:
+		return "Weekend"
+	}
+	var parts []string
+	for i := 0; i < len(weekOrder); i++ {
+		if !w.Has(weekOrder[i]) {
+			continue
+		}
+		j := i
+		for j+1 < len(weekOrder) && w.Has(weekOrder[j+1]) {
+			j++
+		}
+		first := weekOrder[i].String()[:3]
+		last := weekOrder[j].String()[:3]
+		switch j - i {
+		case 0:
+			parts = append(parts, first)
+		case 1:
+			parts = append(parts, first, last)
+		default:
+			parts = append(parts, first+"-"+last)
+		}
+		i = j
+	}
+	return strings.Join(parts, ",")
+}
+
+// time format examples: "Mon 15:00", "Weekday 15:00 2", "Weekend 15:00", "Mon-Tue,Fri 15:00-17:00"
 func (s *Store) Subscribe(userID string, input string) error {
 	if len(userID) == 0 {
 		return errors.New("userID can't be blank")
 	}
-	tr, err := ParseTimeRange(input)
-	if err != nil {
+	tr, err := ParseTimeRange(input)
+	if err != nil {
+		return err
+	}
+	return s.Update(userID, func(user *UserData) error {
+		user.addSubscription(tr)
+		return nil
+	})
+}
+
+func (s *Store) Unsubscribe(userID, input string) error {
+	if len(userID) == 0 {
+		return errors.New("userID can't be blank")
+	}
+	tr, err := ParseTimeRange(input)
+	if err != nil {
+		return fmt.Errorf("incorrect time format: %w", err)
+	}
+	return s.Update(userID, func(user *UserData) error {
+		return user.removeSubscription(tr)
+	})
+}
+
+// PauseSubscription pauses or resumes a single subscription.
+func (s *Store) PauseSubscription(userID, input string, paused bool) error {
+	tr, err := ParseTimeRange(input)
+	if err != nil {
+		return fmt.Errorf("incorrect time format: %w", err)
+	}
+	return s.Update(userID, func(user *UserData) error {
+		return user.pauseSubscription(tr, paused)
+	})
+}
+
+// RemoveSubscription removes the subscription with the ID.
+func (s *Store) RemoveSubscription(userID, subID string) error {
+	return s.Update(userID, func(user *UserData) error {
+		i, err := user.subscription(subID)
+		if err != nil {
+			return err
+		}
+		user.Subscriptions = append(user.Subscriptions[:i], user.Subscriptions[i+1:]...)
+		return nil
+	})
+}
+
+// EditSubscription applies fn to the subscription with the ID, the changed subscription must be valid.
+func (s *Store) EditSubscription(userID, subID string, fn func(sub *Subscription) error) error {
+	return s.Update(userID, func(user *UserData) error {
+		i, err := user.subscription(subID)
+		if err != nil {
+			return err
+		}
+		sub := user.Subscriptions[i]
+		if err := fn(&sub); err != nil {
+			return err
+		}
+		if err := sub.validate(); err != nil {
+			return err
+		}
+		for j, other := range user.Subscriptions {
+			if j != i && other.sameAs(sub) {
+				return errors.New("the same subscription already exists")
+			}
+		}
+		user.Subscriptions[i] = sub
+		return nil
+	})
+}
+
+// DuplicateSubscription adds a copy of the subscription with the ID after it and returns ID of the copy.
+// Subscriptions can't repeat, so the copy starts at the nearest later slot which doesn't repeat another subscription.
+func (s *Store) DuplicateSubscription(userID, subID string) (string, error) {
+	var copyID string
+	err := s.Update(userID, func(user *UserData) error {
+		i, err := user.subscription(subID)
+		if err != nil {
+			return err
+		}
+		sub := user.Subscriptions[i]
+		sub.ID = newSubscriptionID()
+	shift:
+		for {
+			sub.shift(int(SlotDuration / time.Minute))
+			if err := sub.validate(); err != nil {
+				return errors.New("no time left for a copy on the same day")
+			}
+			for _, other := range user.Subscriptions {
+				if other.sameAs(sub) {
+					continue shift
+				}
+			}
+			break
+		}
+		copyID = sub.ID
+		user.Subscriptions = append(user.Subscriptions[:i+1], append([]Subscription{sub}, user.Subscriptions[i+1:]...)...)
+		return nil
+	})
+	return copyID, err
+}
+
+// ReplaceSubscription replaces the subscription with the ID by the parsed input keeping its ID and state.
+func (s *Store) ReplaceSubscription(userID, subID, input string) error {
+	tr, err := ParseTimeRange(input)
+	if err != nil {
+		return err
+	}
+	return s.EditSubscription(userID, subID, func(sub *Subscription) error {
+		tr.ID, tr.Paused = sub.ID, sub.Paused
+		*sub = tr
+		return nil
+	})
+}
+
+// SetCredentials saves encrypted credentials of the user for the venue.
+func (s *Store) SetCredentials(userID, venueID string, creds Credentials) error {
+	if s.Cipher == nil {
+		return errors.New("auto-booking is disabled")
+	}
+	if _, ok := venueBooker(venueID); !ok {
+		return fmt.Errorf("auto-booking isn't supported at %s", venueID)
+	}
+	data, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	secret, err := s.Cipher.Encrypt(data)
+	if err != nil {
+		return err
+	}
+	return s.Update(userID, func(user *UserData) error {
+		if user.Credentials == nil {
+			user.Credentials = make(map[string]string)
+		}
+		user.Credentials[venueID] = secret
+		return nil
+	})
+}
+
+// DeleteCredentials removes credentials of the user for the venue.
+func (s *Store) DeleteCredentials(userID, venueID string) error {
+	return s.Update(userID, func(user *UserData) error {
+		if _, ok := user.Credentials[venueID]; !ok {
+			return errors.New("no saved credentials")
+		}
+		delete(user.Credentials, venueID)
+		return nil
+	})
+}
+
+// credentials returns decrypted credentials of the user for the venue.
+func (s *Store) credentials(user *UserData, venueID string) (Credentials, bool) {
+	secret, ok := user.Credentials[venueID]
+	if !ok || s.Cipher == nil {
+		return Credentials{}, false
+	}
+	data, err := s.Cipher.Decrypt(secret)
+	if err != nil {
+		log.Println("could not decrypt credentials", err)
+		return Credentials{}, false
+	}
+	var creds Credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		log.Println("could not decode credentials", err)
+		return Credentials{}, false
+	}
+	return creds, true
+}
+
+// User returns a copy of the user.
+func (s *Store) User(userID string) (*UserData, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	user, ok := s.Data.Users[userID]
+	if !ok {
+		return nil, false
+	}
+	return user.clone(), true
+}
+
+// Pause stops all notifications for the user until the date in "2006-01-02" format, blank date pauses until resumed.
+func (s *Store) Pause(userID, until string) error {
+	return s.Update(userID, func(user *UserData) error {
+		if len(user.Subscriptions) == 0 {
+			return errors.New("no subscriptions")
+		}
+		if until != "" {
+			loc := user.Location()
+			date, err := time.ParseInLocation(dateLayout, until, loc)
+			if err != nil {
+				return errors.New(`incorrect date format, expected format: "2006-01-02"`)
+			}
+			if !date.After(today(time.Now().In(loc))) {
+				return errors.New("date must be in the future")
+			}
+		}
+		user.Paused = true
+		user.PausedUntil = until
+		return nil
+	})
+}
+
+// Resume restarts notifications paused by Pause.
+func (s *Store) Resume(userID string) error {
+	return s.Update(userID, func(user *UserData) error {
+		user.Paused = false
+		user.PausedUntil = ""
+		return nil
+	})
+}
+
+// SetTimeZone sets IANA timezone used to display times for the user, blank name resets it to the venue timezone.
+func (s *Store) SetTimeZone(userID, name string) error {
+	if name != "" {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return fmt.Errorf("unknown timezone: %s", name)
+		}
+		name = loc.String()
+	}
+	return s.Update(userID, func(user *UserData) error {
+		user.TimeZone = name
+		return nil
+	})
+}
+
+// Update applies fn to a copy of the user and saves it, the user is replaced only when both fn and saving succeed.
+// A new user is passed to fn if the user doesn't exist. Nothing is saved if fn doesn't change the user.
+func (s *Store) Update(userID string, fn func(user *UserData) error) error {
+	s.Lock()
+	defer s.Unlock()
+	user, ok := s.Data.Users[userID]
+	if !ok {
+		user = NewUserData(userID)
+	}
+	updated := user.clone()
+	if err := fn(updated); err != nil {
 		return err
 	}
-	s.Lock()
-	defer s.Unlock()
-	s.addTime(userID, tr)
-	err = s.save()
-	if err != nil {
+	if reflect.DeepEqual(user, updated) {
+		return nil
+	}
+	if err := s.Storage.SaveUser(updated); err != nil {
 		return fmt.Errorf("could not save data: %w", err)
 	}
+	s.Data.Users[userID] = updated
 	return nil
 }
 
-func (s *Store) Unsubscribe(userID, input string) error {
-	if len(userID) == 0 {
-		return errors.New("userID can't be blank")
+// userIDs returns IDs of all users.
+func (s *Store) userIDs() []string {
+	s.RLock()
+	defer s.RUnlock()
+	ids := make([]string, 0, len(s.Data.Users))
+	for id := range s.Data.Users {
+		ids = append(ids, id)
 	}
-	tr, err := ParseTimeRange(input)
-	if err != nil {
-		return fmt.Errorf("incorrect time format: %w", err)
+	return ids
+}
+
+// Location returns timezone used to display times for the user.
+func (s *Store) Location(userID string) *time.Location {
+	s.RLock()
+	defer s.RUnlock()
+	user, ok := s.Data.Users[userID]
+	if !ok {
+		return NewUserData(userID).Location()
 	}
-	s.Lock()
-	defer s.Unlock()
-	err = s.removeTime(userID, tr)
-	if err != nil {
-		return err
+	return user.Location()
+}
+
+// SetChannels sets notification channels of the user, blank channels mean Telegram only.
+func (s *Store) SetChannels(userID string, channels map[string]string) error {
+	for channel := range channels {
+		if _, ok := s.Notifiers[channel]; !ok {
+			return fmt.Errorf("%s notifications aren't available", channel)
+		}
 	}
-	err = s.save()
-	if err != nil {
-		return fmt.Errorf("could not save data: %w", err)
+	return s.Update(userID, func(user *UserData) error {
+		user.Channels = channels
+		return nil
+	})
+}
+
+// Ban bans or unbans the user or group, unknown IDs are rejected so bans don't create empty users.
+func (s *Store) Ban(userID string, banned bool) error {
+	return s.Update(userID, func(user *UserData) error {
+		// fn runs with the store lock
+		if _, ok := s.Data.Users[userID]; !ok {
+			return fmt.Errorf("unknown user or group %s, see /users", userID)
+		}
+		user.Banned = banned
+		return nil
+	})
+}
+
+// Banned reports whether the user or group is banned.
+func (s *Store) Banned(userID string) bool {
+	s.RLock()
+	defer s.RUnlock()
+	user, ok := s.Data.Users[userID]
+	return ok && user.Banned
+}
+
+// UsersList returns a line about every user and group for /users.
+func (s *Store) UsersList(now time.Time) string {
+	s.RLock()
+	defer s.RUnlock()
+	return usersList(s.Data.Users, now)
+}
+
+// Stats are counters shown by /stats.
+type Stats struct {
+	Users         int
+	Groups        int
+	Subscriptions int
+	Paused        int
+	Banned        int
+	// Sent is the number of notifications sent since start
+	Sent      int
+	LastFetch time.Time
+}
+
+// Stats counts users, groups and their subscriptions.
+func (s *Store) Stats() Stats {
+	s.RLock()
+	defer s.RUnlock()
+	stats := Stats{Sent: s.sent, LastFetch: s.lastFetch}
+	for id, user := range s.Data.Users {
+		if strings.HasPrefix(id, "-") {
+			stats.Groups++
+		} else {
+			stats.Users++
+		}
+		stats.Subscriptions += len(user.Subscriptions)
+		if user.IsPaused(time.Now()) {
+			stats.Paused++
+		}
+		if user.Banned {
+			stats.Banned++
+		}
 	}
-	return nil
+	return stats
+}
+
+// Broadcast queues the text to all users and groups which aren't banned over their channels,
+// it returns the number of chats the text is queued to and failed to be queued to.
+func (s *Store) Broadcast(text string) (queued, failed int) {
+	s.RLock()
+	defer s.RUnlock()
+	n := Notification{Subject: "Announcement", Text: html.EscapeString(text)}
+	for _, user := range s.Data.Users {
+		if user.Banned {
+			continue
+		}
+		if !s.send(user, n) {
+			failed++
+			continue
+		}
+		queued++
+	}
+	return queued, failed
 }
 
 func (s *Store) DeleteUser(userID string) error {
 	s.Lock()
 	defer s.Unlock()
+	if err := s.Storage.DeleteUser(userID); err != nil {
+		return fmt.Errorf("could not delete data: %w", err)
+	}
 	delete(s.Data.Users, userID)
-	return s.save()
+	delete(s.unsavedBookings, userID)
+	return nil
 }
 
 func (s *Store) Subscriptions(userID string) string {
@@ -216,49 +1203,313 @@ func (s *Store) Subscriptions(userID string) string {
 	}
 	var subMsgs []string
 	for _, tr := range user.Subscriptions {
+		if tr.Paused {
+			subMsgs = append(subMsgs, tr.String()+" (paused)")
+			continue
+		}
 		subMsgs = append(subMsgs, tr.String())
 	}
 	if len(subMsgs) == 0 {
 		return "no subscriptions"
 	}
+	if user.IsPaused(time.Now()) {
+		if user.PausedUntil != "" {
+			subMsgs = append([]string{fmt.Sprintf("All notifications are paused until %s", user.PausedUntil)}, subMsgs...)
+		} else {
+			subMsgs = append([]string{"All notifications are paused"}, subMsgs...)
+		}
+	}
 	return strings.Join(subMsgs, "\n")
 }
 
-func (s *Store) NotifyAll(b *telebot.Bot, cal Calendar) {
+// removeExpired removes one-off subscriptions for dates which have passed, removed subscriptions are returned by user ID.
+func (s *Store) removeExpired(now time.Time) map[string][]Subscription {
+	result := make(map[string][]Subscription)
+	for _, id := range s.userIDs() {
+		var expired []Subscription
+		err := s.Update(id, func(user *UserData) error {
+			expired = nil
+			kept := make([]Subscription, 0, len(user.Subscriptions))
+			for _, sub := range user.Subscriptions {
+				if sub.Expired(now) {
+					expired = append(expired, sub)
+					continue
+				}
+				kept = append(kept, sub)
+			}
+			user.Subscriptions = kept
+			return nil
+		})
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if len(expired) > 0 {
+			result[id] = expired
+		}
+	}
+	return result
+}
+
+// ExpireAll removes one-off subscriptions for dates which have passed and tells users about it over their channels.
+func (s *Store) ExpireAll(now time.Time) {
+	expired := s.removeExpired(now)
 	s.RLock()
 	defer s.RUnlock()
-	for _, user := range s.Data.Users {
-		err := s.notifyUser(b, user, cal)
+	for userID, subs := range expired {
+		user, ok := s.Data.Users[userID]
+		if !ok {
+			continue
+		}
+		var lines []string
+		for _, sub := range subs {
+			lines = append(lines, sub.String())
+		}
+		s.send(user, Notification{
+			Subject: "Expired subscriptions removed",
+			Text:    html.EscapeString(fmt.Sprintf("Expired subscriptions removed:\n%s", strings.Join(lines, "\n"))),
+		})
+	}
+}
+
+// NotifyAll queues notifications about new slots over channels of users and edits of sent notifications.
+// Slots are marked as notified once a notification is delivered.
+func (s *Store) NotifyAll(venue VenueInfo, cal Calendar) {
+	now := time.Now()
+	s.Lock()
+	s.lastFetch = now
+	s.Unlock()
+	for _, id := range s.userIDs() {
+		lines := s.autoBook(id, venue, cal, now)
+		saved := 0
+		err := s.Update(id, func(user *UserData) error {
+			// bookings which couldn't be saved before are saved with this update
+			saved = len(s.unsavedBookings[id])
+			for _, b := range s.unsavedBookings[id] {
+				b.apply(user)
+			}
+			if len(lines) > 0 {
+				s.send(user, Notification{
+					Subject: fmt.Sprintf("Bookings at %s", venue.Name),
+					Text:    html.EscapeString(strings.Join(lines, "\n")),
+				})
+			}
+			user.pruneAutoBookings(now)
+			user.pruneExpired(now)
+			if user.Banned || user.IsPaused(now) {
+				return nil
+			}
+			s.notifyUser(user, venue, cal, now)
+			s.updateMessages(user, venue, cal, now)
+			return nil
+		})
 		if err != nil {
-			log.Println("could not notify user", err)
+			log.Println(err)
 			continue
 		}
+		if saved > 0 {
+			s.Lock()
+			s.unsavedBookings[id] = s.unsavedBookings[id][saved:]
+			if len(s.unsavedBookings[id]) == 0 {
+				delete(s.unsavedBookings, id)
+			}
+			s.Unlock()
+		}
 	}
 }
 
-func (s *Store) notifyUser(b *telebot.Bot, user *UserData, cal Calendar) error {
-	userCal := cal.ForUserSubscriptions(user)
-	if len(userCal) == 0 {
+// deliver starts sending a message unless a message with the same key is waiting, it must be called with the store lock.
+// send must call done from a worker once the message is delivered or failed, update is applied to the user after delivery.
+func (s *Store) deliver(userID, key string, send func(done func(update func(user *UserData), err error)) error) error {
+	if s.pending == nil {
+		s.pending = make(map[string]bool)
+	}
+	if s.pending[key] {
 		return nil
 	}
-	id, err := strconv.Atoi(user.ID)
-	if err != nil {
-		return err
+	release := func() {
+		s.Lock()
+		delete(s.pending, key)
+		s.Unlock()
 	}
-	teleUser := &telebot.User{ID: int64(id)}
-	msg := fmt.Sprintf("New booking available:\n%s", userCal.String())
-	_, err = b.Send(teleUser, msg)
-	if err != nil {
+	done := func(update func(user *UserData), err error) {
+		if err != nil {
+			release()
+			return
+		}
+		// don't bring back data of a user deleted in the meantime
+		if _, ok := s.User(userID); !ok {
+			release()
+			return
+		}
+		err = s.Update(userID, func(user *UserData) error {
+			delete(s.pending, key)
+			update(user)
+			return nil
+		})
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	if err := send(done); err != nil {
 		return err
 	}
-	for t := range userCal {
-		user.addToNotified(t)
+	s.pending[key] = true
+	return nil
+}
+
+// updateMessages queues edits of sent notifications for the venue to mark slots which are no longer free.
+// Messages about slots in the past aren't tracked anymore.
+func (s *Store) updateMessages(user *UserData, venue VenueInfo, cal Calendar, now time.Time) {
+	kept := user.Messages[:0]
+	for _, m := range user.Messages {
+		if m.Venue != venue.ID {
+			kept = append(kept, m)
+			continue
+		}
+		if m.expired(now) {
+			continue
+		}
+		text := renderNotification(venue, m.Slots, cal, user.Location())
+		if text != m.Text && s.Queue != nil {
+			messageID := m.MessageID
+			out := OutMessage{
+				Edit: telebot.StoredMessage{MessageID: strconv.Itoa(m.MessageID), ChatID: m.ChatID},
+				What: text,
+				// the poll keyboard is removed unless it's sent again, responses can change while the edit is queued
+				OptsFunc: func() []interface{} {
+					return []interface{}{telebot.ModeHTML, s.pollMarkup(user.ID, messageID)}
+				},
+			}
+			edited := func(user *UserData) {
+				for i := range user.Messages {
+					if user.Messages[i].MessageID == messageID {
+						user.Messages[i].Text = text
+					}
+				}
+			}
+			err := s.deliver(user.ID, fmt.Sprintf("edit %s %d", user.ID, messageID), func(done func(func(*UserData), error)) error {
+				out.Sent = func(*telebot.Message) {
+					done(edited, nil)
+				}
+				out.Failed = func(err error) {
+					// the message already has the text, so the edit isn't retried
+					if notModified(err) {
+						done(edited, nil)
+						return
+					}
+					if !uneditable(err) {
+						done(nil, err)
+						return
+					}
+					// the edit fails the same way every time, so the message isn't tracked anymore
+					done(func(user *UserData) {
+						kept := user.Messages[:0]
+						for _, m := range user.Messages {
+							if m.MessageID != messageID {
+								kept = append(kept, m)
+							}
+						}
+						user.Messages = kept
+					}, nil)
+				}
+				if !s.Queue.Enqueue(out) {
+					return errQueueFull
+				}
+				return nil
+			})
+			if err != nil {
+				log.Println("could not edit message", err)
+			}
+		}
+		kept = append(kept, m)
+	}
+	user.Messages = kept
+}
+
+// pollMarkup returns the current poll keyboard of the sent message, nil if the message isn't tracked anymore.
+func (s *Store) pollMarkup(userID string, messageID int) *telebot.ReplyMarkup {
+	s.RLock()
+	defer s.RUnlock()
+	user, ok := s.Data.Users[userID]
+	if !ok {
+		return nil
+	}
+	for i := range user.Messages {
+		if user.Messages[i].MessageID == messageID {
+			return user.Messages[i].pollMarkup()
+		}
+	}
+	return nil
+}
+
+// send sends the notification over all channels of the user without tracking its delivery,
+// it reports whether the notification is queued over all channels.
+func (s *Store) send(user *UserData, n Notification) bool {
+	ok := true
+	for channel, address := range user.channels() {
+		notifier, found := s.Notifiers[channel]
+		if !found {
+			log.Printf("could not notify %s: unknown channel %s", user.ID, channel)
+			ok = false
+			continue
+		}
+		if err := notifier.Notify(address, n, nil); err != nil {
+			log.Printf("could not notify %s by %s: %s", user.ID, channel, err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// notifyUser queues notifications about new slots matching subscriptions of the user over all channels of the user.
+// Slots are marked as notified over a channel when its notification is delivered, so failed channels are retried.
+func (s *Store) notifyUser(user *UserData, venue VenueInfo, cal Calendar, now time.Time) {
+	matches := cal.matchUserSubscriptions(user, venue.ID)
+	user.forgetNotified(venue, cal, matches, now)
+	for channel, address := range user.channels() {
+		channel := channel
+		userCal := matches.withoutNotified(user, venue.ID, channel)
+		if len(userCal) == 0 {
+			continue
+		}
+		notifier, ok := s.Notifiers[channel]
+		if !ok {
+			log.Printf("could not notify %s: unknown channel %s", user.ID, channel)
+			continue
+		}
+		n := Notification{
+			Subject: fmt.Sprintf("New booking available at %s", venue.Name),
+			Text:    renderNotification(venue, userCal, userCal, user.Location()),
+			Venue:   venue,
+			Slots:   userCal,
+			Players: requiredPlayers(user, venue.ID, userCal),
+		}
+		key := fmt.Sprintf("notify %s %s %s", user.ID, venue.ID, channel)
+		err := s.deliver(user.ID, key, func(done func(func(*UserData), error)) error {
+			return notifier.Notify(address, n, func(sent *SentMessage, err error) {
+				done(func(user *UserData) {
+					if sent != nil {
+						user.Messages = append(user.Messages, *sent)
+					}
+					for t := range userCal {
+						user.addToNotified(Slot{Venue: venue.ID, Time: t, Channel: channel})
+					}
+					s.sent++
+				}, err)
+			})
+		})
+		if err != nil {
+			log.Printf("could not notify %s by %s: %s", user.ID, channel, err)
+		}
 	}
-	return s.save()
 }
 
 func parseTime(timeS string) (Clock, error) {
 	clock := strings.Split(timeS, ":")
+	if len(clock) != 2 {
+		return Clock{}, fmt.Errorf("incorrect time format: \"%s\"", timeS)
+	}
 	hour, err := strconv.ParseInt(clock[0], 10, 64)
 	if err != nil {
 		return Clock{}, fmt.Errorf("incorrect time format: \"%s\"", timeS)
@@ -279,56 +1530,131 @@ func parseTime(timeS string) (Clock, error) {
 	}, nil
 }
 
-func (s *Store) save() error {
-	if err := s.File.Truncate(0); err != nil {
-		return err
+// parseSlotTime parses time which must be at the start of a slot.
+func parseSlotTime(timeS string) (Clock, error) {
+	clock, err := parseTime(timeS)
+	if err != nil {
+		return Clock{}, err
 	}
-	if _, err := s.File.Seek(0, 0); err != nil {
-		return err
+	if time.Duration(clock.Minutes())*time.Minute%SlotDuration != 0 {
+		return Clock{}, fmt.Errorf("time must be a multiple of %s: \"%s\"", formatMinutes(int(SlotDuration/time.Minute)), timeS)
+	}
+	return clock, nil
+}
+
+// Courts is a sorted list of court numbers
+type Courts []int
+
+// courtRange returns courts numbered from 1 to count
+func courtRange(count int) Courts {
+	courts := make(Courts, count)
+	for i := range courts {
+		courts[i] = i + 1
 	}
-	enc := json.NewEncoder(s.File)
-	enc.SetIndent("", "  ")
-	return enc.Encode(s.Data)
+	return courts
 }
 
-type Calendar map[time.Time]uint
+func (c Courts) without(court int) Courts {
+	result := make(Courts, 0, len(c))
+	for _, v := range c {
+		if v != court {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func (c Courts) intersect(other Courts) Courts {
+	result := make(Courts, 0, len(c))
+	for _, v := range c {
+		if other.has(v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func (c Courts) union(other Courts) Courts {
+	result := append(Courts{}, c...)
+	for _, v := range other {
+		if !result.has(v) {
+			result = append(result, v)
+		}
+	}
+	sort.Ints(result)
+	return result
+}
+
+func (c Courts) has(court int) bool {
+	for _, v := range c {
+		if v == court {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Courts) String() string {
+	s := make([]string, len(c))
+	for i, v := range c {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ", ")
+}
+
+// Calendar holds free courts for every slot, slots are SlotDuration long
+type Calendar map[time.Time]Courts
 
-func NewCalendar(start, end time.Time) Calendar {
+// NewCalendar creates a calendar with all courts free for days from start to end in the venue timezone.
+// Slots are calculated from the wall clock of each day, so days with DST transitions are handled correctly.
+func NewCalendar(venue VenueInfo, start, end time.Time) Calendar {
 	c := make(Calendar)
-	start = time.Date(start.Year(), start.Month(), start.Day(), StartHour, 0, 0, 0, start.Location())
-	end = time.Date(end.Year(), end.Month(), end.Day(), EndHour, 0, 0, 0, end.Location())
-	for t := start; t.Before(end); t = t.Add(MinBookingDuration) {
-		if t.Hour() < StartHour || t.Hour() > EndHour {
-			continue
+	loc := venue.Location
+	if loc == nil {
+		loc = start.Location()
+	}
+	start, end = start.In(loc), end.In(loc)
+	slot := int(SlotDuration / time.Minute)
+	for day := today(start); !day.After(end); day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, loc) {
+		for m := venue.Open.Minutes(); m < venue.Close.Minutes(); m += slot {
+			t := time.Date(day.Year(), day.Month(), day.Day(), 0, m, 0, 0, loc)
+			// the wall clock time doesn't exist when clocks are moved forward
+			if clockOf(t).Minutes() != m {
+				continue
+			}
+			c[t] = append(Courts{}, venue.Courts...)
 		}
-		c[t] = CourtsCount
 	}
 	return c
 }
 
+// slotStart returns the start of the slot containing t.
+func slotStart(t time.Time) time.Time {
+	minutes := t.Hour()*60 + t.Minute()
+	minutes -= minutes % int(SlotDuration/time.Minute)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, minutes, 0, 0, t.Location())
+}
+
 func (cal Calendar) Book(b Booking) {
-	// some bookings start and end at :30 minutes mark.
-	// for example 5:30-6:30, such interval are unavailable for us, so we need to reserve 5:00-7:00 slot in this case
-	start := b.Start
-	start = time.Date(start.Year(), start.Month(), start.Day(), start.Hour(), 0, 0, 0, start.Location())
-	end := b.End
-	end = time.Date(end.Year(), end.Month(), end.Day(), end.Hour(), 0, 0, 0, end.Location())
-	if b.End.Sub(end) > 0 {
-		end = end.Add(time.Hour)
+	// bookings not aligned to slots block every slot they overlap,
+	// for example with 30 minutes slots 5:15-6:15 reserves 5:00-6:30
+	end := slotStart(b.End)
+	if b.End.After(end) {
+		end = end.Add(SlotDuration)
 	}
-	for t := start; t.Before(end); t = t.Add(MinBookingDuration) {
-		if cal[t] > 0 {
-			cal[t]--
+	for t := slotStart(b.Start); t.Before(end); t = t.Add(SlotDuration) {
+		if courts, ok := cal[t]; ok {
+			cal[t] = courts.without(b.Court)
 		}
 	}
 }
 
 func (cal Calendar) IsAvailable(start, end time.Time) bool {
-	if end.After(start) {
+	if !end.After(start) {
 		return false
 	}
-	for t := start; t.Before(end); t = t.Add(MinBookingDuration) {
-		if cal[t] == 0 {
+	for t := start; t.Before(end); t = t.Add(SlotDuration) {
+		if len(cal[t]) == 0 {
 			return false
 		}
 	}
@@ -338,7 +1664,7 @@ func (cal Calendar) IsAvailable(start, end time.Time) bool {
 func (cal Calendar) NonZero() Calendar {
 	c := make(Calendar)
 	for t, v := range cal {
-		if v > 0 {
+		if len(v) > 0 {
 			c[t] = v
 		}
 	}
@@ -348,8 +1674,8 @@ func (cal Calendar) NonZero() Calendar {
 func (cal Calendar) String() string {
 	var buf bytes.Buffer
 	for _, v := range cal.toSlice() {
-		if v.Count > 0 {
-			buf.WriteString(fmt.Sprintf("%s - %d\n", v.Time.Format("2006-01-02 15:04"), v.Count))
+		if len(v.Courts) > 0 {
+			buf.WriteString(fmt.Sprintf("%s - courts %s\n", v.Time.Format("2006-01-02 15:04"), v.Courts))
 		}
 	}
 	return buf.String()
@@ -357,50 +1683,175 @@ func (cal Calendar) String() string {
 
 func (cal Calendar) ForSubscription(subscription Subscription) Calendar {
 	result := make(Calendar)
-slotIter:
-	for t, slots := range cal {
-		if t.Weekday() != subscription.Weekday {
+	for _, block := range cal.blocks(subscription) {
+		result.merge(block)
+	}
+	return result
+}
+
+// blocks returns blocks of slots which can be booked for the subscription sorted by start time.
+// For windows only the best blocks of each day, the ones with the most courts available, are returned.
+func (cal Calendar) blocks(subscription Subscription) []Calendar {
+	var result []Calendar
+	if subscription.IsWindow() {
+		result = cal.forWindow(subscription)
+	} else {
+		for t := range cal {
+			if !subscription.MatchesDay(t) || clockOf(t) != subscription.Time {
+				continue
+			}
+			if block, ok := cal.block(t, subscription); ok {
+				result = append(result, block)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].toSlice()[0].Time.Before(result[j].toSlice()[0].Time)
+	})
+	return result
+}
+
+// forWindow finds blocks of subscription.Minutes between subscription.Time and subscription.End.
+// Only the best blocks of each day, the ones with the most courts available, are returned.
+func (cal Calendar) forWindow(subscription Subscription) []Calendar {
+	type dayBest struct {
+		courts int
+		blocks []Calendar
+	}
+	best := make(map[time.Time]*dayBest)
+	for t := range cal {
+		if !subscription.MatchesDay(t) {
 			continue
 		}
-		if t.Hour() != subscription.Time.Hour {
+		start := clockOf(t).Minutes()
+		if start < subscription.Time.Minutes() || start+subscription.Minutes > subscription.End.Minutes() {
 			continue
 		}
-		// first slot found
-		dayCal := Calendar{t: slots}
-		// now check if we can book all slots
-		for i := 1; i < subscription.Hours; i++ {
-			nextTime := t.Add(time.Hour * time.Duration(i))
-			nextSlots, ok := cal[nextTime]
-			if !ok {
-				continue slotIter
-			}
-			dayCal[nextTime] = nextSlots
+		block, ok := cal.block(t, subscription)
+		if !ok {
+			continue
+		}
+		courts := block.minCount()
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		b, ok := best[day]
+		switch {
+		case !ok || courts > b.courts:
+			best[day] = &dayBest{courts: courts, blocks: []Calendar{block}}
+		case courts == b.courts:
+			b.blocks = append(b.blocks, block)
+		}
+	}
+	var result []Calendar
+	for _, b := range best {
+		result = append(result, b.blocks...)
+	}
+	return result
+}
+
+// block returns slots starting at start if enough courts are available in each of them for the subscription.
+// Unless the subscription allows switching courts, only courts which are free for the whole block are returned.
+func (cal Calendar) block(start time.Time, subscription Subscription) (Calendar, bool) {
+	result := make(Calendar)
+	var same Courts
+	end := start.Add(subscription.Duration())
+	for t := start; t.Before(end); t = t.Add(SlotDuration) {
+		courts, ok := cal[t]
+		if !ok {
+			return nil, false
+		}
+		if t.Equal(start) {
+			same = courts
+		} else {
+			same = same.intersect(courts)
+		}
+		if len(courts) < int(subscription.MinCourts()) {
+			return nil, false
+		}
+		result[t] = courts
+	}
+	if subscription.CourtSwitch {
+		return result, true
+	}
+	if len(same) < int(subscription.MinCourts()) {
+		return nil, false
+	}
+	for t := range result {
+		result[t] = same
+	}
+	return result, true
+}
+
+func (cal Calendar) minCount() int {
+	var result int
+	first := true
+	for _, v := range cal {
+		if first || len(v) < result {
+			result = len(v)
+			first = false
 		}
-		// record we can book all slots
-		for k, v := range dayCal {
-			result[k] = v
+	}
+	return result
+}
+
+// clone returns a deep copy of the calendar.
+func (cal Calendar) clone() Calendar {
+	if cal == nil {
+		return nil
+	}
+	result := make(Calendar, len(cal))
+	for t, courts := range cal {
+		result[t] = append(Courts(nil), courts...)
+	}
+	return result
+}
+
+// merge adds courts from other calendar
+func (cal Calendar) merge(other Calendar) {
+	for k, v := range other {
+		cal[k] = cal[k].union(v)
+	}
+}
+
+// ForUserSubscriptions returns slots matching user subscriptions for the venue which the user hasn't been notified about.
+func (cal Calendar) ForUserSubscriptions(user *UserData, venue string) Calendar {
+	return cal.matchUserSubscriptions(user, venue).withoutNotified(user, venue, "")
+}
+
+// withoutNotified returns slots the user hasn't been notified about over the channel, blank for all channels.
+func (cal Calendar) withoutNotified(user *UserData, venue, channel string) Calendar {
+	result := make(Calendar)
+	for t, v := range cal {
+		if !user.isNotified(Slot{Venue: venue, Time: t, Channel: channel}) {
+			result[t] = v
 		}
 	}
 	return result
 }
 
-func (cal Calendar) ForUserSubscriptions(user *UserData) Calendar {
+// matchUserSubscriptions returns slots matching user subscriptions for the venue.
+func (cal Calendar) matchUserSubscriptions(user *UserData, venue string) Calendar {
 	result := make(Calendar)
 	for _, subscription := range user.Subscriptions {
-		subCal := cal.ForSubscription(subscription)
-		for k, v := range subCal {
-			result[k] = v
+		if subscription.Paused || subscription.VenueID() != venue {
+			continue
 		}
+		result.merge(cal.ForSubscription(subscription))
 	}
-	for t := range user.Notified {
-		delete(result, t)
+	return result
+}
+
+// In returns a copy of the calendar with times in loc.
+func (cal Calendar) In(loc *time.Location) Calendar {
+	result := make(Calendar, len(cal))
+	for t, v := range cal {
+		result[t.In(loc)] = v
 	}
 	return result
 }
 
 type Entry struct {
-	Time  time.Time
-	Count uint
+	Time   time.Time
+	Courts Courts
 }
 
 func (cal Calendar) toSlice() []Entry {
@@ -420,34 +1871,8 @@ type Booking struct {
 	End   time.Time `json:"end"`
 }
 
-func fetchData() ([]Booking, error) {
-	const layout = "2006-01-02"
-	url := fmt.Sprintf("https://platform.aklbadminton.com/api/booking/feed?start=%s&end=%s", time.Now().Format(layout), time.Now().Add(time.Hour*24*8).Format(layout))
-	log.Println("fetching", url)
-	resp, err := http.DefaultClient.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("unexpected error code %d", resp.StatusCode)
-	}
-	var data []Booking
-	err = json.NewDecoder(resp.Body).Decode(&data)
-	if err != nil {
-		return nil, err
-	}
-	log.Printf("fetched %d bookings", len(data))
-	return data, nil
-}
-
-func fetchCalendar() (Calendar, error) {
-	data, err := fetchData()
-	if err != nil {
-		return nil, err
-	}
-	cal := NewCalendar(time.Now(), time.Now().Add(time.Hour*24*7))
-	for _, b := range data {
-		cal.Book(b)
-	}
-	return cal, nil
+// In returns the booking with times in loc.
+func (b Booking) In(loc *time.Location) Booking {
+	b.Start, b.End = b.Start.In(loc), b.End.In(loc)
+	return b
 }
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -13,12 +17,12 @@ func TestParseTimeRange(t *testing.T) {
 		tr, err := ParseTimeRange("Mon 16:00")
 		require.NoError(t, err)
 		assert.Equal(t, Subscription{
-			Weekday: time.Monday,
+			Days: NewWeekdays(time.Monday),
 			Time: Clock{
 				Hour:   16,
 				Minute: 0,
 			},
-			Hours: 1,
+			Minutes: 60,
 		}, tr)
 		assert.Equal(t, "Mon 16:00-17:00", tr.String())
 	})
@@ -27,107 +31,812 @@ func TestParseTimeRange(t *testing.T) {
 		tr, err := ParseTimeRange("Mon 16:00 2")
 		require.NoError(t, err)
 		assert.Equal(t, Subscription{
-			Weekday: time.Monday,
+			Days: NewWeekdays(time.Monday),
 			Time: Clock{
 				Hour:   16,
 				Minute: 0,
 			},
-			Hours: 2,
+			Minutes: 120,
 		}, tr)
 		assert.Equal(t, "Mon 16:00-18:00", tr.String())
 	})
 
+	t.Run("with end time", func(t *testing.T) {
+		tr, err := ParseTimeRange("Mon-Fri 17:00-21:00")
+		require.NoError(t, err)
+		assert.Equal(t, Subscription{
+			Days: WorkingDays,
+			Time: Clock{
+				Hour:   17,
+				Minute: 0,
+			},
+			Minutes: 240,
+		}, tr)
+		assert.Equal(t, "Weekdays 17:00-21:00", tr.String())
+	})
+
+	t.Run("day groups and lists", func(t *testing.T) {
+		tr, err := ParseTimeRange("weekend,Wed  10:30 2")
+		require.NoError(t, err)
+		assert.Equal(t, NewWeekdays(time.Wednesday, time.Saturday, time.Sunday), tr.Days)
+		assert.Equal(t, "Wed,Sat,Sun 10:30-12:30", tr.String())
+	})
+
+	t.Run("window", func(t *testing.T) {
+		tr, err := ParseTimeRange("Weekday 17:00-22:00 2")
+		require.NoError(t, err)
+		assert.Equal(t, Subscription{
+			Days:    WorkingDays,
+			Time:    Clock{Hour: 17},
+			Minutes: 120,
+			End:     Clock{Hour: 22},
+		}, tr)
+		assert.True(t, tr.IsWindow())
+		assert.Equal(t, "Weekdays 17:00-22:00 2", tr.String())
+	})
+
+	t.Run("window of the same length as duration", func(t *testing.T) {
+		tr, err := ParseTimeRange("Mon 15:00-17:00 2")
+		require.NoError(t, err)
+		assert.False(t, tr.IsWindow())
+		assert.Equal(t, "Mon 15:00-17:00", tr.String())
+	})
+
+	t.Run("with courts", func(t *testing.T) {
+		tr, err := ParseTimeRange("Tue 19:00 2 x3")
+		require.NoError(t, err)
+		assert.Equal(t, Subscription{
+			Days:    NewWeekdays(time.Tuesday),
+			Time:    Clock{Hour: 19},
+			Minutes: 120,
+			Courts:  3,
+		}, tr)
+		assert.Equal(t, uint(3), tr.MinCourts())
+		assert.Equal(t, "Tue 19:00-21:00 x3", tr.String())
+
+		tr, err = ParseTimeRange("Tue 19:00 x1")
+		require.NoError(t, err)
+		assert.Equal(t, 0, tr.Courts)
+		assert.Equal(t, uint(1), tr.MinCourts())
+		assert.Equal(t, "Tue 19:00-20:00", tr.String())
+
+		tr, err = ParseTimeRange("Weekday 17:00-22:00 2 x2")
+		require.NoError(t, err)
+		assert.Equal(t, "Weekdays 17:00-22:00 2 x2", tr.String())
+	})
+
+	t.Run("with court switch", func(t *testing.T) {
+		tr, err := ParseTimeRange("Tue 19:00 2 x2 switch")
+		require.NoError(t, err)
+		assert.True(t, tr.CourtSwitch)
+		assert.Equal(t, 2, tr.Courts)
+		assert.Equal(t, "Tue 19:00-21:00 x2 switch", tr.String())
+	})
+
+	t.Run("until midnight", func(t *testing.T) {
+		tr, err := ParseTimeRange("Mon 23:00 1")
+		require.NoError(t, err)
+		assert.Equal(t, "Mon 23:00-24:00", tr.String())
+	})
+
+	t.Run("half hours", func(t *testing.T) {
+		tr, err := ParseTimeRange("Mon 18:30 1.5")
+		require.NoError(t, err)
+		assert.Equal(t, Subscription{
+			Days:    NewWeekdays(time.Monday),
+			Time:    Clock{Hour: 18, Minute: 30},
+			Minutes: 90,
+		}, tr)
+		assert.Equal(t, "Mon 18:30-20:00", tr.String())
+
+		tr, err = ParseTimeRange("Mon 18:30-20:30")
+		require.NoError(t, err)
+		assert.Equal(t, 120, tr.Minutes)
+
+		tr, err = ParseTimeRange("Mon 17:30-22:00 1.5")
+		require.NoError(t, err)
+		assert.Equal(t, "Mon 17:30-22:00 1.5", tr.String())
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		for _, input := range []string{
+			"Mon",
+			"Mnday 15:00",
+			"Mon-Fr 15:00",
+			"Mon 15:00-15:00",
+			"Mon 15:00-16:15",
+			"Mon 15:10",
+			"Mon 15:00 1.25",
+			"Mon 15:00 0.5",
+			"Mon 15:00-17:00 3",
+			"Mon 15:00 0",
+			"Mon 15:00 2 x0",
+			"Mon 15:00 2 xx",
+			"Mon 23:30 1",
+			"Mon 23:00 2",
+		} {
+			_, err := ParseTimeRange(input)
+			assert.Error(t, err, input)
+		}
+	})
+}
+
+func TestParseTimeRange_Date(t *testing.T) {
+	date := time.Now().AddDate(0, 0, 3).Format("2006-01-02")
+	tr, err := ParseTimeRange(date + " 10:00 2")
+	require.NoError(t, err)
+	assert.Equal(t, Subscription{
+		Time:    Clock{Hour: 10},
+		Minutes: 120,
+		Date:    date,
+	}, tr)
+	assert.Equal(t, date+" 10:00-12:00", tr.String())
+
+	_, err = ParseTimeRange(time.Now().Format("2006-01-02") + " 10:00")
+	assert.NoError(t, err, "today is allowed")
+
+	_, err = ParseTimeRange(time.Now().AddDate(0, 0, -1).Format("2006-01-02") + " 10:00")
+	assert.Error(t, err)
+}
+
+func TestSubscription_String_RoundTrip(t *testing.T) {
+	north := NewABAVenue()
+	north.ID = "north"
+	withVenues(t, VenueList{NewABAVenue(), north})
+	date := time.Now().AddDate(0, 0, 3).Format("2006-01-02")
+	subs := []Subscription{
+		{Days: WorkingDays, Time: Clock{Hour: 17}, End: Clock{Hour: 22}, Minutes: 120},
+		{Days: NewWeekdays(time.Monday), Time: Clock{Hour: 17, Minute: 30}, End: Clock{Hour: 22}, Minutes: 90, Courts: 2},
+		{Date: date, Time: Clock{Hour: 10}, Minutes: 120},
+		{Days: NewWeekdays(time.Saturday), Time: Clock{Hour: 10}, Minutes: 60, Venue: "north"},
+		{Days: NewWeekdays(time.Monday, time.Wednesday), Time: Clock{Hour: 18}, Minutes: 120, Courts: 2, CourtSwitch: true, AutoBook: true, Players: 4},
+	}
+	for _, sub := range subs {
+		t.Run(sub.String(), func(t *testing.T) {
+			parsed, err := ParseTimeRange(sub.String())
+			require.NoError(t, err)
+			assert.Equal(t, sub, parsed)
+		})
+	}
+}
+
+func TestParseWeekdays(t *testing.T) {
+	for input, expected := range map[string]Weekdays{
+		"mon":             NewWeekdays(time.Monday),
+		"Weekday":         WorkingDays,
+		"weekends":        Weekend,
+		"daily":           AllDays,
+		"mon-fri":         WorkingDays,
+		"sat-mon":         NewWeekdays(time.Saturday, time.Sunday, time.Monday),
+		"mon,wed,fri":     NewWeekdays(time.Monday, time.Wednesday, time.Friday),
+		"tue-thu,sun":     NewWeekdays(time.Tuesday, time.Wednesday, time.Thursday, time.Sunday),
+		"weekend,mon-fri": AllDays,
+	} {
+		days, err := ParseWeekdays(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, days, input)
+	}
+}
+
+func TestWeekdays_String(t *testing.T) {
+	assert.Equal(t, "Mon", NewWeekdays(time.Monday).String())
+	assert.Equal(t, "Mon,Tue", NewWeekdays(time.Monday, time.Tuesday).String())
+	assert.Equal(t, "Mon-Wed,Fri", NewWeekdays(time.Monday, time.Tuesday, time.Wednesday, time.Friday).String())
+	assert.Equal(t, "Weekend", Weekend.String())
+	assert.Equal(t, "Weekdays", WorkingDays.String())
+	assert.Equal(t, "Daily", AllDays.String())
+}
+
+// halfHours splits hourly slots into half-hour slots
+func halfHours(cal Calendar) Calendar {
+	result := make(Calendar)
+	for t, v := range cal {
+		result[t] = v
+		result[t.Add(30*time.Minute)] = v
+	}
+	return result
+}
+
+func TestStore_Subscribe(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	require.NoError(t, store.Subscribe("1", "Mon-Fri 17:00-21:00"))
+	require.NoError(t, store.Subscribe("1", "weekday 17:00 4"))
+	assert.Equal(t, "Weekdays 17:00-21:00", store.Subscriptions("1"))
+	require.NoError(t, store.Unsubscribe("1", "mon,tue,wed,thu,fri 17:00-21:00"))
+	assert.Equal(t, "no subscriptions", store.Subscriptions("1"))
 }
 
 func Test_NewCalendar(t *testing.T) {
-	calendar := NewCalendar(time.Date(2020, 1, 1, 6, 0, 0, 0, time.Local), time.Date(2020, 1, 2, 18, 0, 0, 0, time.Local))
-	fmt.Println(calendar)
+	venue := VenueInfo{Courts: Courts{1, 2}, Open: Clock{Hour: 6}, Close: Clock{Hour: 21, Minute: 30}}
+	calendar := NewCalendar(venue, time.Date(2020, 1, 1, 6, 0, 0, 0, time.Local), time.Date(2020, 1, 2, 18, 0, 0, 0, time.Local))
+	assert.Len(t, calendar, 2*31)
+	assert.Equal(t, Courts{1, 2}, calendar[time.Date(2020, 1, 1, 6, 0, 0, 0, time.Local)])
+	assert.Equal(t, Courts{1, 2}, calendar[time.Date(2020, 1, 2, 21, 0, 0, 0, time.Local)])
+	assert.NotContains(t, calendar, time.Date(2020, 1, 2, 21, 30, 0, 0, time.Local))
 }
 
 func TestCalendar_Available(t *testing.T) {
-	data, err := fetchData()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "2022-02-13", r.URL.Query().Get("start"))
+		assert.Equal(t, "2022-02-21", r.URL.Query().Get("end"))
+		fmt.Fprint(w, TestData)
+	}))
+	defer server.Close()
+	venue := NewABAVenue()
+	venue.URL = server.URL
+
+	// the server timezone doesn't matter
+	cal, err := fetchCalendar(venue, time.Date(2022, 2, 12, 18, 0, 0, 0, time.UTC))
 	require.NoError(t, err)
-	cal := NewCalendar(time.Now(), time.Now().Add(time.Hour*24*7))
-	for _, b := range data {
-		cal.Book(b)
+	assert.Equal(t, Courts{2, 3}, cal[time.Date(2022, 2, 13, 19, 0, 0, 0, abaLocation)])
+	assert.Equal(t, Courts{}, cal[time.Date(2022, 2, 13, 6, 0, 0, 0, abaLocation)])
+	assert.Len(t, cal[time.Date(2022, 2, 14, 6, 0, 0, 0, abaLocation)], len(venue.Courts))
+}
+
+func TestNewCalendar_DST(t *testing.T) {
+	venue := VenueInfo{Courts: Courts{1}, Open: Clock{Hour: 1}, Close: Clock{Hour: 4}, Location: abaLocation}
+	// clocks go forward from 2:00 to 3:00 on 2022-09-25 in New Zealand
+	cal := NewCalendar(venue, time.Date(2022, 9, 25, 0, 0, 0, 0, abaLocation), time.Date(2022, 9, 25, 0, 0, 0, 0, abaLocation))
+	assert.Len(t, cal, 4)
+	assert.Contains(t, cal, time.Date(2022, 9, 25, 1, 30, 0, 0, abaLocation))
+	assert.Contains(t, cal, time.Date(2022, 9, 25, 3, 0, 0, 0, abaLocation))
+	// clocks go back from 3:00 to 2:00 on 2022-04-03
+	cal = NewCalendar(venue, time.Date(2022, 4, 3, 0, 0, 0, 0, abaLocation), time.Date(2022, 4, 3, 0, 0, 0, 0, abaLocation))
+	assert.Len(t, cal, 6)
+
+	// slots on the following days aren't shifted by the transition
+	venue.Open, venue.Close = Clock{Hour: 18}, Clock{Hour: 19}
+	cal = NewCalendar(venue, time.Date(2022, 9, 24, 0, 0, 0, 0, abaLocation), time.Date(2022, 9, 26, 0, 0, 0, 0, abaLocation))
+	for slot := range cal {
+		assert.Equal(t, 18, slot.Hour(), slot)
 	}
-	fmt.Println(cal.NonZero().String())
+}
+
+func TestCalendar_In(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	require.NoError(t, err)
+	cal := Calendar{time.Date(2022, 2, 13, 19, 0, 0, 0, abaLocation): {1}}
+	assert.Equal(t, "2022-02-13 06:00 - courts 1\n", cal.In(london).String())
 }
 
 func TestCalendar_Book(t *testing.T) {
 	cal := Calendar{
-		time.Date(2020, 1, 1, 6, 0, 0, 0, time.Local): 1,
-		time.Date(2020, 1, 1, 7, 0, 0, 0, time.Local): 1,
-		time.Date(2020, 1, 1, 8, 0, 0, 0, time.Local): 1,
-		time.Date(2020, 1, 1, 9, 0, 0, 0, time.Local): 1,
+		time.Date(2020, 1, 1, 7, 0, 0, 0, time.Local):  {1, 2},
+		time.Date(2020, 1, 1, 7, 30, 0, 0, time.Local): {1, 2},
+		time.Date(2020, 1, 1, 8, 0, 0, 0, time.Local):  {1, 2},
+		time.Date(2020, 1, 1, 8, 30, 0, 0, time.Local): {1, 2},
+		time.Date(2020, 1, 1, 9, 0, 0, 0, time.Local):  {1, 2},
+		time.Date(2020, 1, 1, 9, 30, 0, 0, time.Local): {1, 2},
 	}
 	cal.Book(Booking{
+		Court: 2,
 		Start: time.Date(2020, 1, 1, 7, 30, 0, 0, time.Local),
 		End:   time.Date(2020, 1, 1, 8, 30, 0, 0, time.Local),
 	})
+	// not aligned to slots
+	cal.Book(Booking{
+		Court: 1,
+		Start: time.Date(2020, 1, 1, 8, 45, 0, 0, time.Local),
+		End:   time.Date(2020, 1, 1, 9, 15, 0, 0, time.Local),
+	})
 	assert.Equal(t, Calendar{
-		time.Date(2020, 1, 1, 6, 0, 0, 0, time.Local): 1,
-		time.Date(2020, 1, 1, 7, 0, 0, 0, time.Local): 0,
-		time.Date(2020, 1, 1, 8, 0, 0, 0, time.Local): 0,
-		time.Date(2020, 1, 1, 9, 0, 0, 0, time.Local): 1,
+		time.Date(2020, 1, 1, 7, 0, 0, 0, time.Local):  {1, 2},
+		time.Date(2020, 1, 1, 7, 30, 0, 0, time.Local): {1},
+		time.Date(2020, 1, 1, 8, 0, 0, 0, time.Local):  {1},
+		time.Date(2020, 1, 1, 8, 30, 0, 0, time.Local): {2},
+		time.Date(2020, 1, 1, 9, 0, 0, 0, time.Local):  {2},
+		time.Date(2020, 1, 1, 9, 30, 0, 0, time.Local): {1, 2},
 	}, cal)
 }
 
-func TestCalendar_ForSubscription(t *testing.T) {
-	// 1-1-2020 is Wednesday
+func TestCalendar_String(t *testing.T) {
 	cal := Calendar{
-		time.Date(2020, 1, 1, 6, 0, 0, 0, time.Local): 1,
-		time.Date(2020, 1, 1, 7, 0, 0, 0, time.Local): 2,
-		time.Date(2020, 1, 1, 8, 0, 0, 0, time.Local): 3,
-		time.Date(2020, 1, 1, 9, 0, 0, 0, time.Local): 4,
+		time.Date(2020, 1, 1, 7, 0, 0, 0, time.Local): {},
+		time.Date(2020, 1, 1, 8, 0, 0, 0, time.Local): {3, 7},
+		time.Date(2020, 1, 1, 6, 0, 0, 0, time.Local): {1},
 	}
+	assert.Equal(t, "2020-01-01 06:00 - courts 1\n2020-01-01 08:00 - courts 3, 7\n", cal.String())
+}
+
+func TestCalendar_ForSubscription(t *testing.T) {
+	// 1-1-2020 is Wednesday
+	cal := halfHours(Calendar{
+		time.Date(2020, 1, 1, 6, 0, 0, 0, time.Local): {1},
+		time.Date(2020, 1, 1, 7, 0, 0, 0, time.Local): {1, 2},
+		time.Date(2020, 1, 1, 8, 0, 0, 0, time.Local): {1, 2, 3},
+		time.Date(2020, 1, 1, 9, 0, 0, 0, time.Local): {1, 2, 3, 4},
+	})
 	t.Run("no match", func(t *testing.T) {
 		assert.Equal(t, Calendar{}, cal.ForSubscription(Subscription{
-			Weekday: time.Monday,
+			Days: NewWeekdays(time.Monday),
 			Time: Clock{
 				Hour:   6,
 				Minute: 0,
 			},
-			Hours: 1,
+			Minutes: 60,
 		}))
 	})
 
 	t.Run("match", func(t *testing.T) {
-		assert.Equal(t, Calendar{
-			time.Date(2020, 1, 1, 6, 0, 0, 0, time.Local): 1,
-		}, cal.ForSubscription(Subscription{
-			Weekday: time.Wednesday,
+		assert.Equal(t, halfHours(Calendar{
+			time.Date(2020, 1, 1, 6, 0, 0, 0, time.Local): {1},
+		}), cal.ForSubscription(Subscription{
+			Days: NewWeekdays(time.Wednesday),
 			Time: Clock{
 				Hour:   6,
 				Minute: 0,
 			},
-			Hours: 1,
+			Minutes: 60,
 		}))
 	})
 
 	t.Run("double booking match", func(t *testing.T) {
-		assert.Equal(t, Calendar{
-			time.Date(2020, 1, 1, 6, 0, 0, 0, time.Local): 1,
-			time.Date(2020, 1, 1, 7, 0, 0, 0, time.Local): 2,
-		}, cal.ForSubscription(Subscription{
-			Weekday: time.Wednesday,
+		assert.Equal(t, halfHours(Calendar{
+			time.Date(2020, 1, 1, 6, 0, 0, 0, time.Local): {1},
+			time.Date(2020, 1, 1, 7, 0, 0, 0, time.Local): {1},
+		}), cal.ForSubscription(Subscription{
+			Days: NewWeekdays(time.Wednesday),
 			Time: Clock{
 				Hour:   6,
 				Minute: 0,
 			},
-			Hours: 2,
+			Minutes: 120,
 		}))
 	})
 
 	t.Run("no match for a double booking", func(t *testing.T) {
+		// 10:00 is past the last slot of the calendar
 		assert.Equal(t, Calendar{}, cal.ForSubscription(Subscription{
-			Weekday: time.Wednesday,
+			Days: NewWeekdays(time.Wednesday),
 			Time: Clock{
-				Hour:   8,
+				Hour:   9,
 				Minute: 0,
 			},
-			Hours: 2,
+			Minutes: 120,
+		}))
+	})
+
+	t.Run("double booking match at the end of the calendar", func(t *testing.T) {
+		assert.Equal(t, halfHours(Calendar{
+			time.Date(2020, 1, 1, 8, 0, 0, 0, time.Local): {1, 2, 3},
+			time.Date(2020, 1, 1, 9, 0, 0, 0, time.Local): {1, 2, 3},
+		}), cal.ForSubscription(Subscription{
+			Days:    NewWeekdays(time.Wednesday),
+			Time:    Clock{Hour: 8},
+			Minutes: 120,
+		}))
+	})
+
+	t.Run("no match for an unavailable slot", func(t *testing.T) {
+		cal := halfHours(Calendar{
+			time.Date(2020, 1, 1, 6, 0, 0, 0, time.Local): {},
+			time.Date(2020, 1, 1, 7, 0, 0, 0, time.Local): {1, 2},
+		})
+		assert.Equal(t, Calendar{}, cal.ForSubscription(Subscription{
+			Days:    NewWeekdays(time.Wednesday),
+			Time:    Clock{Hour: 6},
+			Minutes: 120,
+		}))
+	})
+}
+
+func TestCalendar_ForSubscription_SameCourt(t *testing.T) {
+	// 1-1-2020 is Wednesday
+	cal := halfHours(Calendar{
+		time.Date(2020, 1, 1, 18, 0, 0, 0, time.Local): {3, 5},
+		time.Date(2020, 1, 1, 19, 0, 0, 0, time.Local): {5, 7},
+		time.Date(2020, 1, 1, 20, 0, 0, 0, time.Local): {7},
+	})
+	t.Run("same court for the whole booking", func(t *testing.T) {
+		assert.Equal(t, halfHours(Calendar{
+			time.Date(2020, 1, 1, 18, 0, 0, 0, time.Local): {5},
+			time.Date(2020, 1, 1, 19, 0, 0, 0, time.Local): {5},
+		}), cal.ForSubscription(Subscription{
+			Days:    NewWeekdays(time.Wednesday),
+			Time:    Clock{Hour: 18},
+			Minutes: 120,
+		}))
+	})
+
+	t.Run("no single court for the whole booking", func(t *testing.T) {
+		assert.Equal(t, Calendar{}, cal.ForSubscription(Subscription{
+			Days:    NewWeekdays(time.Wednesday),
+			Time:    Clock{Hour: 18},
+			Minutes: 180,
+		}))
+	})
+
+	t.Run("court switch allowed", func(t *testing.T) {
+		assert.Equal(t, halfHours(Calendar{
+			time.Date(2020, 1, 1, 18, 0, 0, 0, time.Local): {3, 5},
+			time.Date(2020, 1, 1, 19, 0, 0, 0, time.Local): {5, 7},
+			time.Date(2020, 1, 1, 20, 0, 0, 0, time.Local): {7},
+		}), cal.ForSubscription(Subscription{
+			Days:        NewWeekdays(time.Wednesday),
+			Time:        Clock{Hour: 18},
+			Minutes:     180,
+			CourtSwitch: true,
+		}))
+	})
+
+	t.Run("several courts for the whole booking", func(t *testing.T) {
+		assert.Equal(t, Calendar{}, cal.ForSubscription(Subscription{
+			Days:    NewWeekdays(time.Wednesday),
+			Time:    Clock{Hour: 18},
+			Minutes: 120,
+			Courts:  2,
+		}))
+	})
+}
+
+func TestCalendar_ForSubscription_Courts(t *testing.T) {
+	// 1-1-2020 is Wednesday
+	cal := halfHours(Calendar{
+		time.Date(2020, 1, 1, 18, 0, 0, 0, time.Local): {1, 2, 3},
+		time.Date(2020, 1, 1, 19, 0, 0, 0, time.Local): {1, 2},
+		time.Date(2020, 1, 1, 20, 0, 0, 0, time.Local): {1, 2, 3, 4},
+	})
+	t.Run("every hour has enough courts", func(t *testing.T) {
+		assert.Equal(t, halfHours(Calendar{
+			time.Date(2020, 1, 1, 19, 0, 0, 0, time.Local): {1, 2},
+			time.Date(2020, 1, 1, 20, 0, 0, 0, time.Local): {1, 2},
+		}), cal.ForSubscription(Subscription{
+			Days:    NewWeekdays(time.Wednesday),
+			Time:    Clock{Hour: 19},
+			Minutes: 120,
+			Courts:  2,
+		}))
+	})
+
+	t.Run("one hour lacks courts", func(t *testing.T) {
+		assert.Equal(t, Calendar{}, cal.ForSubscription(Subscription{
+			Days:    NewWeekdays(time.Wednesday),
+			Time:    Clock{Hour: 18},
+			Minutes: 120,
+			Courts:  3,
+		}))
+	})
+
+	t.Run("window", func(t *testing.T) {
+		assert.Equal(t, halfHours(Calendar{
+			time.Date(2020, 1, 1, 20, 0, 0, 0, time.Local): {1, 2, 3, 4},
+		}), cal.ForSubscription(Subscription{
+			Days:    NewWeekdays(time.Wednesday),
+			Time:    Clock{Hour: 18},
+			Minutes: 60,
+			End:     Clock{Hour: 21},
+			Courts:  4,
+		}))
+	})
+}
+
+func TestCalendar_ForSubscription_Window(t *testing.T) {
+	// 1-1-2020 is Wednesday
+	cal := halfHours(Calendar{
+		time.Date(2020, 1, 1, 17, 0, 0, 0, time.Local): {1},
+		time.Date(2020, 1, 1, 18, 0, 0, 0, time.Local): {1, 2, 3},
+		time.Date(2020, 1, 1, 19, 0, 0, 0, time.Local): {1, 2, 3},
+		time.Date(2020, 1, 1, 20, 0, 0, 0, time.Local): {1},
+		time.Date(2020, 1, 1, 21, 0, 0, 0, time.Local): {},
+		time.Date(2020, 1, 2, 17, 0, 0, 0, time.Local): {},
+		time.Date(2020, 1, 2, 18, 0, 0, 0, time.Local): {1},
+		time.Date(2020, 1, 2, 19, 0, 0, 0, time.Local): {},
+		time.Date(2020, 1, 2, 20, 0, 0, 0, time.Local): {1, 2},
+		time.Date(2020, 1, 2, 21, 0, 0, 0, time.Local): {1, 2},
+	})
+	sub := Subscription{
+		Days:    WorkingDays,
+		Time:    Clock{Hour: 17},
+		Minutes: 120,
+		End:     Clock{Hour: 22},
+	}
+
+	t.Run("best blocks for each day", func(t *testing.T) {
+		assert.Equal(t, halfHours(Calendar{
+			time.Date(2020, 1, 1, 18, 0, 0, 0, time.Local): {1, 2, 3},
+			time.Date(2020, 1, 1, 19, 0, 0, 0, time.Local): {1, 2, 3},
+			time.Date(2020, 1, 2, 20, 0, 0, 0, time.Local): {1, 2},
+			time.Date(2020, 1, 2, 21, 0, 0, 0, time.Local): {1, 2},
+		}), cal.ForSubscription(sub))
+	})
+
+	t.Run("block must end before the window end", func(t *testing.T) {
+		sub := sub
+		sub.End = Clock{Hour: 21}
+		assert.Equal(t, halfHours(Calendar{
+			time.Date(2020, 1, 1, 18, 0, 0, 0, time.Local): {1, 2, 3},
+			time.Date(2020, 1, 1, 19, 0, 0, 0, time.Local): {1, 2, 3},
+		}), cal.ForSubscription(sub))
+	})
+
+	t.Run("ties are all reported", func(t *testing.T) {
+		sub := sub
+		sub.Minutes = 180
+		assert.Equal(t, halfHours(Calendar{
+			time.Date(2020, 1, 1, 17, 0, 0, 0, time.Local): {1},
+			time.Date(2020, 1, 1, 18, 0, 0, 0, time.Local): {1},
+			time.Date(2020, 1, 1, 19, 0, 0, 0, time.Local): {1},
+			time.Date(2020, 1, 1, 20, 0, 0, 0, time.Local): {1},
+		}), cal.ForSubscription(sub))
+	})
+}
+
+func TestCalendar_ForSubscription_HalfHour(t *testing.T) {
+	// 1-1-2020 is Wednesday
+	cal := Calendar{
+		time.Date(2020, 1, 1, 18, 0, 0, 0, time.Local):  {},
+		time.Date(2020, 1, 1, 18, 30, 0, 0, time.Local): {1},
+		time.Date(2020, 1, 1, 19, 0, 0, 0, time.Local):  {1},
+		time.Date(2020, 1, 1, 19, 30, 0, 0, time.Local): {1},
+		time.Date(2020, 1, 1, 20, 0, 0, 0, time.Local):  {1},
+		time.Date(2020, 1, 1, 20, 30, 0, 0, time.Local): {},
+	}
+	t.Run("start at half past", func(t *testing.T) {
+		assert.Equal(t, Calendar{
+			time.Date(2020, 1, 1, 18, 30, 0, 0, time.Local): {1},
+			time.Date(2020, 1, 1, 19, 0, 0, 0, time.Local):  {1},
+			time.Date(2020, 1, 1, 19, 30, 0, 0, time.Local): {1},
+			time.Date(2020, 1, 1, 20, 0, 0, 0, time.Local):  {1},
+		}, cal.ForSubscription(Subscription{
+			Days:    NewWeekdays(time.Wednesday),
+			Time:    Clock{Hour: 18, Minute: 30},
+			Minutes: 120,
+		}))
+	})
+
+	t.Run("minutes are not ignored", func(t *testing.T) {
+		assert.Equal(t, Calendar{}, cal.ForSubscription(Subscription{
+			Days:    NewWeekdays(time.Wednesday),
+			Time:    Clock{Hour: 18},
+			Minutes: 60,
+		}))
+	})
+
+	t.Run("window", func(t *testing.T) {
+		assert.Equal(t, Calendar{
+			time.Date(2020, 1, 1, 18, 30, 0, 0, time.Local): {1},
+			time.Date(2020, 1, 1, 19, 0, 0, 0, time.Local):  {1},
+			time.Date(2020, 1, 1, 19, 30, 0, 0, time.Local): {1},
+		}, cal.ForSubscription(Subscription{
+			Days:    NewWeekdays(time.Wednesday),
+			Time:    Clock{Hour: 18},
+			Minutes: 90,
+			End:     Clock{Hour: 20},
+		}))
+	})
+}
+
+func TestCalendar_ForSubscription_Date(t *testing.T) {
+	cal := halfHours(Calendar{
+		time.Date(2020, 1, 1, 10, 0, 0, 0, time.Local): {1},
+		time.Date(2020, 1, 8, 10, 0, 0, 0, time.Local): {2},
+	})
+	assert.Equal(t, halfHours(Calendar{
+		time.Date(2020, 1, 8, 10, 0, 0, 0, time.Local): {2},
+	}), cal.ForSubscription(Subscription{
+		Time:    Clock{Hour: 10},
+		Minutes: 60,
+		Date:    "2020-01-08",
+	}))
+}
+
+func TestStore_Pause(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	require.NoError(t, store.Subscribe("1", "Mon 18:00 2"))
+	require.NoError(t, store.Subscribe("1", "Tue 18:00 2"))
+
+	require.NoError(t, store.PauseSubscription("1", "Mon 18:00-20:00", true))
+	assert.Equal(t, "Mon 18:00-20:00 (paused)\nTue 18:00-20:00", store.Subscriptions("1"))
+	assert.Error(t, store.PauseSubscription("1", "Wed 18:00 2", true))
+
+	until := time.Now().AddDate(0, 0, 7).Format("2006-01-02")
+	require.NoError(t, store.Pause("1", until))
+	assert.Equal(t, "All notifications are paused until "+until+"\nMon 18:00-20:00 (paused)\nTue 18:00-20:00", store.Subscriptions("1"))
+	assert.Error(t, store.Pause("1", time.Now().Format("2006-01-02")))
+	assert.Error(t, store.Pause("1", "next week"))
+
+	require.NoError(t, store.Resume("1"))
+	require.NoError(t, store.PauseSubscription("1", "Mon 18:00 2", false))
+	assert.Equal(t, "Mon 18:00-20:00\nTue 18:00-20:00", store.Subscriptions("1"))
+
+	// paused subscriptions can still be removed
+	require.NoError(t, store.PauseSubscription("1", "Mon 18:00 2", true))
+	require.NoError(t, store.Unsubscribe("1", "Mon 18:00 2"))
+	assert.Equal(t, "Tue 18:00-20:00", store.Subscriptions("1"))
+}
+
+func TestStore_SetTimeZone(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	assert.Equal(t, "Pacific/Auckland", store.Location("1").String())
+	require.NoError(t, store.SetTimeZone("1", "Europe/London"))
+	assert.Equal(t, "Europe/London", store.Location("1").String())
+	assert.Error(t, store.SetTimeZone("1", "Mars/Olympus"))
+	require.NoError(t, store.SetTimeZone("1", ""))
+	assert.Equal(t, "Pacific/Auckland", store.Location("1").String())
+}
+
+func TestStore_NotifyAll_PrunePaused(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	venue := NewABAVenue().Info()
+	past := today(time.Now().In(venue.Location)).AddDate(0, 0, -1).Add(18 * time.Hour)
+	next := past.AddDate(0, 0, 2)
+	for _, id := range []string{"1", "2"} {
+		require.NoError(t, store.Subscribe(id, "Mon 18:00"))
+		require.NoError(t, store.Update(id, func(user *UserData) error {
+			user.addToNotified(Slot{Venue: venue.ID, Time: past})
+			user.addToNotified(Slot{Venue: venue.ID, Time: next})
+			user.Messages = []SentMessage{
+				{MessageID: 1, Venue: venue.ID, Slots: Calendar{past: {1}}},
+				{MessageID: 2, Venue: venue.ID, Slots: Calendar{next: {1}}},
+			}
+			return nil
 		}))
+	}
+	require.NoError(t, store.Pause("1", ""))
+	require.NoError(t, store.Ban("2", true))
+
+	store.NotifyAll(venue, Calendar{})
+	for _, id := range []string{"1", "2"} {
+		user, _ := store.User(id)
+		assert.Equal(t, map[Slot]struct{}{{Venue: venue.ID, Time: next.UTC()}: {}}, user.Notified, id)
+		require.Len(t, user.Messages, 1, id)
+		assert.Equal(t, 2, user.Messages[0].MessageID, id)
+	}
+}
+
+func TestUserData_IsPaused(t *testing.T) {
+	now := time.Date(2020, 1, 8, 12, 0, 0, 0, abaLocation)
+	assert.False(t, (&UserData{}).IsPaused(now))
+	assert.True(t, (&UserData{Paused: true}).IsPaused(now))
+	assert.True(t, (&UserData{Paused: true, PausedUntil: "2020-01-09"}).IsPaused(now))
+	// it's already 2020-01-09 in Auckland
+	assert.False(t, (&UserData{Paused: true, PausedUntil: "2020-01-09"}).IsPaused(time.Date(2020, 1, 8, 12, 0, 0, 0, time.UTC)))
+	assert.True(t, (&UserData{Paused: true, PausedUntil: "2020-01-09", TimeZone: "UTC"}).IsPaused(time.Date(2020, 1, 8, 12, 0, 0, 0, time.UTC)))
+	assert.False(t, (&UserData{Paused: true, PausedUntil: "2020-01-08"}).IsPaused(now))
+}
+
+func TestUserData_forgetNotified(t *testing.T) {
+	venue := VenueInfo{ID: "aba", Location: abaLocation}
+	at := func(hour int) time.Time {
+		return time.Date(2022, 2, 13, hour, 0, 0, 0, abaLocation)
+	}
+	cal := Calendar{
+		at(17): {1},
+		at(18): {},
+		at(19): {1},
+	}
+	matches := Calendar{at(19): {1}}
+	user := NewUserData("1")
+	user.addToNotified(Slot{Venue: "aba", Time: at(12)})
+	user.addToNotified(Slot{Venue: "aba", Time: at(18)})
+	user.addToNotified(Slot{Venue: "aba", Time: at(19)})
+	user.addToNotified(Slot{Venue: "aba", Time: at(20)})
+	user.addToNotified(Slot{Venue: "other", Time: at(18)})
+	user.addToNotified(Slot{Venue: "other", Time: at(10)})
+
+	user.forgetNotified(venue, cal, matches, at(13))
+	assert.Equal(t, map[Slot]struct{}{
+		// still available
+		Slot{Venue: "aba", Time: at(19).UTC()}: {},
+		// not in the calendar
+		Slot{Venue: "aba", Time: at(20).UTC()}: {},
+		// other venue
+		Slot{Venue: "other", Time: at(18).UTC()}: {},
+	}, user.Notified)
+
+	// taken slot is announced again once it's free
+	cal[at(18)] = Courts{2}
+	matches[at(18)] = Courts{2}
+	assert.Equal(t, Calendar{at(18): {2}}, matches.withoutNotified(user, "aba", ""))
+}
+
+// failingStorage counts saves and fails them when err is set.
+type failingStorage struct {
+	Storage
+	saves int
+	err   error
+}
+
+func (s *failingStorage) SaveUser(user *UserData) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.saves++
+	return s.Storage.SaveUser(user)
+}
+
+func TestStore_Update(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	storage := &failingStorage{Storage: store.Storage}
+	store.Storage = storage
+
+	require.NoError(t, store.Subscribe("1", "Mon 18:00"))
+	assert.Equal(t, 1, storage.saves)
+
+	// unchanged user isn't saved
+	require.NoError(t, store.Subscribe("1", "Mon 18:00"))
+	require.NoError(t, store.Resume("2"))
+	assert.Equal(t, 1, storage.saves)
+	assert.NotContains(t, store.Data.Users, "2")
+
+	// nothing changes if saving fails
+	storage.err = errors.New("disk full")
+	assert.Error(t, store.Subscribe("1", "Tue 18:00"))
+	assert.Error(t, store.Subscribe("2", "Tue 18:00"))
+	assert.Equal(t, "Mon 18:00-19:00", store.Subscriptions("1"))
+	assert.NotContains(t, store.Data.Users, "2")
+
+	// or fn fails
+	storage.err = nil
+	err = store.Update("1", func(user *UserData) error {
+		user.Subscriptions = nil
+		return errors.New("failed")
 	})
+	assert.EqualError(t, err, "failed")
+	assert.Equal(t, "Mon 18:00-19:00", store.Subscriptions("1"))
+	assert.Equal(t, 1, storage.saves)
+}
+
+func TestStore_removeExpired(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	weekly := Subscription{ID: "1", Days: AllDays, Time: Clock{Hour: 10}, Minutes: 60}
+	past := Subscription{ID: "2", Date: "2020-01-08", Time: Clock{Hour: 10}, Minutes: 60}
+	current := Subscription{ID: "3", Date: "2020-01-09", Time: Clock{Hour: 10}, Minutes: 60}
+	require.NoError(t, store.Update("1", func(user *UserData) error {
+		user.Subscriptions = []Subscription{weekly, past, current}
+		return nil
+	}))
+	require.NoError(t, store.Update("2", func(user *UserData) error {
+		user.addSubscription(weekly)
+		return nil
+	}))
+
+	// it's already 2020-01-09 in the venue timezone
+	expired := store.removeExpired(time.Date(2020, 1, 8, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, map[string][]Subscription{"1": {past}}, expired)
+	assert.Equal(t, []Subscription{weekly, current}, store.Data.Users["1"].Subscriptions)
+	assert.Equal(t, []Subscription{weekly}, store.Data.Users["2"].Subscriptions)
+}
+
+func TestStore_ExpireAll(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	telegram := &fakeNotifier{}
+	store.Notifiers = map[string]Notifier{ChannelTelegram: telegram}
+	require.NoError(t, store.Update("1", func(user *UserData) error {
+		user.Subscriptions = []Subscription{{ID: "1", Date: "2020-01-08", Time: Clock{Hour: 10}, Minutes: 60}}
+		return nil
+	}))
+	require.NoError(t, store.Subscribe("2", "Mon 18:00"))
+
+	store.ExpireAll(time.Date(2020, 1, 8, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, []string{"1: Expired subscriptions removed:\n2020-01-08 10:00-11:00"}, telegram.texts)
+}
 
+func TestCalendar_ForUserSubscriptions(t *testing.T) {
+	// 1-1-2020 is Wednesday
+	cal := halfHours(Calendar{
+		time.Date(2020, 1, 1, 18, 0, 0, 0, time.Local): {3, 5},
+		time.Date(2020, 1, 1, 19, 0, 0, 0, time.Local): {5, 7},
+	})
+	user := NewUserData("1")
+	user.Subscriptions = []Subscription{
+		{Days: AllDays, Time: Clock{Hour: 18}, Minutes: 120},
+		{Days: AllDays, Time: Clock{Hour: 19}, Minutes: 60},
+		{Days: AllDays, Time: Clock{Hour: 18}, Minutes: 60, Venue: "other"},
+		{Days: AllDays, Time: Clock{Hour: 18}, Minutes: 60, Paused: true},
+	}
+	user.addToNotified(Slot{Venue: "aba", Time: time.Date(2020, 1, 1, 18, 0, 0, 0, time.Local)})
+	user.addToNotified(Slot{Venue: "aba", Time: time.Date(2020, 1, 1, 18, 30, 0, 0, time.Local)})
+	// notifications for other venues don't matter
+	user.addToNotified(Slot{Venue: "other", Time: time.Date(2020, 1, 1, 19, 0, 0, 0, time.Local)})
+	assert.Equal(t, halfHours(Calendar{
+		time.Date(2020, 1, 1, 19, 0, 0, 0, time.Local): {5, 7},
+	}), cal.ForUserSubscriptions(user, "aba"))
 }
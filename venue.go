@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	// venues may be in a timezone which isn't installed on the server
+	_ "time/tzdata"
+)
+
+// VenueInfo describes courts and opening hours of a venue.
+type VenueInfo struct {
+	ID     string
+	Name   string
+	Courts Courts
+	Open   Clock
+	Close  Clock
+	// Location is the venue timezone, all calendar times are in this timezone
+	Location *time.Location
+}
+
+// Venue is a source of court bookings.
+type Venue interface {
+	Info() VenueInfo
+	// Bookings returns bookings between start and end dates
+	Bookings(start, end time.Time) ([]Booking, error)
+}
+
+type VenueList []Venue
+
+// Venues available for subscriptions, the first one is used when a subscription doesn't specify a venue.
+var Venues = VenueList{NewABAVenue()}
+
+// Get finds a venue by ID, blank ID returns the default venue.
+func (l VenueList) Get(id string) (Venue, bool) {
+	if len(l) == 0 {
+		return nil, false
+	}
+	if id == "" {
+		return l[0], true
+	}
+	for _, v := range l {
+		if v.Info().ID == id {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// DefaultID returns ID of the default venue.
+func (l VenueList) DefaultID() string {
+	if len(l) == 0 {
+		return ""
+	}
+	return l[0].Info().ID
+}
+
+func (l VenueList) String() string {
+	var lines []string
+	for _, v := range l {
+		info := v.Info()
+		lines = append(lines, fmt.Sprintf("@%s - %s, %d courts, %s-%s", info.ID, info.Name, len(info.Courts), info.Open, info.Close))
+	}
+	return strings.Join(lines, "\n")
+}
+
+type venueConfig struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Courts int    `json:"courts"`
+	Open   string `json:"open"`
+	Close  string `json:"close"`
+	// TimeZone is IANA timezone name, e.g. "Pacific/Auckland"
+	TimeZone string `json:"timezone"`
+	// BookingURL enables auto-booking at the venue
+	BookingURL string `json:"booking_url"`
+}
+
+// LoadVenues reads venues from a JSON config file, the default venues are returned if the file doesn't exist.
+func LoadVenues(path string) (VenueList, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Venues, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var configs []venueConfig
+	if err := json.NewDecoder(file).Decode(&configs); err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	var result VenueList
+	for _, c := range configs {
+		v, err := c.venue()
+		if err != nil {
+			return nil, fmt.Errorf("venue %s: %w", c.ID, err)
+		}
+		result = append(result, v)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no venues in %s", path)
+	}
+	return result, nil
+}
+
+func (c venueConfig) venue() (Venue, error) {
+	if c.ID == "" || strings.ContainsAny(c.ID, "@ ") {
+		return nil, errors.New("venue ID must be non-blank and can't contain spaces or @")
+	}
+	if c.Courts < 1 {
+		return nil, errors.New("venue must have at least one court")
+	}
+	open, err := parseTime(c.Open)
+	if err != nil {
+		return nil, err
+	}
+	closing, err := parseTime(c.Close)
+	if err != nil {
+		return nil, err
+	}
+	loc := time.Local
+	if c.TimeZone != "" {
+		loc, err = time.LoadLocation(c.TimeZone)
+		if err != nil {
+			return nil, err
+		}
+	}
+	info := VenueInfo{
+		ID:       strings.ToLower(c.ID),
+		Name:     c.Name,
+		Courts:   courtRange(c.Courts),
+		Open:     open,
+		Close:    closing,
+		Location: loc,
+	}
+	switch c.Type {
+	case "aba", "":
+		url := c.URL
+		if url == "" {
+			url = abaFeedURL
+		}
+		return &ABAVenue{VenueInfo: info, URL: url, BookingURL: c.BookingURL, Client: http.DefaultClient}, nil
+	default:
+		return nil, fmt.Errorf("unknown venue type: %s", c.Type)
+	}
+}
+
+// fetchCalendar returns availability for a week starting from now.
+func fetchCalendar(v Venue, now time.Time) (Calendar, error) {
+	info := v.Info()
+	now = now.In(info.Location)
+	data, err := v.Bookings(now, now.Add(time.Hour*24*8))
+	if err != nil {
+		return nil, err
+	}
+	cal := NewCalendar(info, now, now.Add(time.Hour*24*7))
+	for _, b := range data {
+		cal.Book(b.In(info.Location))
+	}
+	return cal, nil
+}
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
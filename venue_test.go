@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withVenues(t *testing.T, venues VenueList) {
+	old := Venues
+	Venues = venues
+	t.Cleanup(func() {
+		Venues = old
+	})
+}
+
+func TestLoadVenues(t *testing.T) {
+	t.Run("missing file", func(t *testing.T) {
+		venues, err := LoadVenues(filepath.Join(t.TempDir(), "venues.json"))
+		require.NoError(t, err)
+		assert.Equal(t, Venues, venues)
+	})
+
+	t.Run("config", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "venues.json")
+		require.NoError(t, os.WriteFile(path, []byte(`[
+			{"id": "aba", "name": "ABA Stadium", "courts": 12, "open": "06:00", "close": "22:00"},
+			{"id": "North", "name": "North Hall", "type": "aba", "url": "http://localhost/feed", "courts": 4, "open": "09:00", "close": "21:30", "timezone": "Pacific/Auckland"}
+		]`), 0600))
+		venues, err := LoadVenues(path)
+		require.NoError(t, err)
+		require.Len(t, venues, 2)
+		assert.Equal(t, "aba", venues.DefaultID())
+		north, ok := venues.Get("north")
+		require.True(t, ok)
+		assert.Equal(t, VenueInfo{
+			ID:       "north",
+			Name:     "North Hall",
+			Courts:   Courts{1, 2, 3, 4},
+			Open:     Clock{Hour: 9},
+			Close:    Clock{Hour: 21, Minute: 30},
+			Location: abaLocation,
+		}, north.Info())
+		assert.Equal(t, "http://localhost/feed", north.(*ABAVenue).URL)
+		assert.Equal(t, "@aba - ABA Stadium, 12 courts, 06:00-22:00\n@north - North Hall, 4 courts, 09:00-21:30", venues.String())
+	})
+
+	t.Run("unknown timezone", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "venues.json")
+		require.NoError(t, os.WriteFile(path, []byte(`[{"id": "x", "courts": 1, "open": "06:00", "close": "22:00", "timezone": "Mars/Olympus"}]`), 0600))
+		_, err := LoadVenues(path)
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown type", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "venues.json")
+		require.NoError(t, os.WriteFile(path, []byte(`[{"id": "x", "type": "unknown", "courts": 1, "open": "06:00", "close": "22:00"}]`), 0600))
+		_, err := LoadVenues(path)
+		assert.Error(t, err)
+	})
+}
+
+func TestParseTimeRange_Venue(t *testing.T) {
+	north := NewABAVenue()
+	north.ID = "north"
+	withVenues(t, VenueList{NewABAVenue(), north})
+
+	tr, err := ParseTimeRange("Sat 10:00 2 x2 @North")
+	require.NoError(t, err)
+	assert.Equal(t, "north", tr.Venue)
+	assert.Equal(t, "north", tr.VenueID())
+	assert.Equal(t, "Sat 10:00-12:00 x2 @north", tr.String())
+
+	// the default venue isn't stored
+	tr, err = ParseTimeRange("Sat 10:00 @aba")
+	require.NoError(t, err)
+	assert.Equal(t, "", tr.Venue)
+	assert.Equal(t, "aba", tr.VenueID())
+
+	_, err = ParseTimeRange("Sat 10:00 @south")
+	assert.Error(t, err)
+}
+
+func TestSlot_JSON(t *testing.T) {
+	zone := time.FixedZone("NZDT", 13*60*60)
+	notified := map[Slot]struct{}{
+		{Venue: "north", Time: time.Date(2022, 2, 13, 19, 0, 0, 0, zone)}:                         {},
+		{Venue: "north", Time: time.Date(2022, 2, 13, 19, 30, 0, 0, zone), Channel: ChannelEmail}: {},
+	}
+	data, err := json.Marshal(notified)
+	require.NoError(t, err)
+	assert.Equal(t, `{"north@2022-02-13T19:00:00+13:00":{},"north@2022-02-13T19:30:00+13:00@email":{}}`, string(data))
+
+	var decoded map[Slot]struct{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, map[Slot]struct{}{
+		{Venue: "north", Time: time.Date(2022, 2, 13, 6, 0, 0, 0, time.UTC)}:                         {},
+		{Venue: "north", Time: time.Date(2022, 2, 13, 6, 30, 0, 0, time.UTC), Channel: ChannelEmail}: {},
+	}, decoded)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"2022-02-13T19:00:00+13:00":{}}`), &decoded))
+	assert.Error(t, json.Unmarshal([]byte(`{"north@2022-02-13T19:00:00+13:00@email@x":{}}`), &decoded))
+}
+
+func TestUserData_isNotified(t *testing.T) {
+	user := NewUserData("1")
+	user.Channels = map[string]string{ChannelTelegram: "", ChannelEmail: "bob@example.com"}
+	at := time.Date(2022, 2, 13, 19, 0, 0, 0, abaLocation)
+	user.addToNotified(Slot{Venue: "aba", Time: at, Channel: ChannelEmail})
+	assert.True(t, user.isNotified(Slot{Venue: "aba", Time: at, Channel: ChannelEmail}))
+	assert.False(t, user.isNotified(Slot{Venue: "aba", Time: at, Channel: ChannelTelegram}))
+	assert.True(t, user.notifiedOverAny(Slot{Venue: "aba", Time: at}))
+
+	// slots without a channel count for all channels
+	user.addToNotified(Slot{Venue: "aba", Time: at.Add(SlotDuration)})
+	assert.True(t, user.isNotified(Slot{Venue: "aba", Time: at.Add(SlotDuration), Channel: ChannelTelegram}))
+	assert.False(t, user.notifiedOverAny(Slot{Venue: "aba", Time: at.Add(2 * SlotDuration)}))
+}
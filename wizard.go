@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Callback uniques of the /add wizard buttons.
+const (
+	wizardDay     = "add_day"
+	wizardDays    = "add_days"
+	wizardVenue   = "add_venue"
+	wizardTime    = "add_time"
+	wizardMinutes = "add_minutes"
+	wizardCourts  = "add_courts"
+	wizardConfirm = "add_confirm"
+	wizardCancel  = "add_cancel"
+)
+
+// wizardMaxCourts is the largest number of courts offered by the wizard.
+const wizardMaxCourts = 4
+
+// wizardState is the subscription built by the /add wizard so far.
+// It's passed in the callback data of the buttons, so the wizard doesn't need to keep any state.
+type wizardState struct {
+	Days    Weekdays
+	Start   int
+	Minutes int
+	Courts  int
+	// Venue is the venue ID, blank for the default venue
+	Venue string
+}
+
+func (s wizardState) String() string {
+	return fmt.Sprintf("%d|%d|%d|%d|%s", s.Days, s.Start, s.Minutes, s.Courts, s.Venue)
+}
+
+func parseWizardState(data string) (wizardState, error) {
+	fields := strings.Split(data, "|")
+	if len(fields) != 5 {
+		return wizardState{}, fmt.Errorf("incorrect wizard state: %q", data)
+	}
+	var values [4]int
+	for i, field := range fields[:4] {
+		v, err := strconv.Atoi(field)
+		if err != nil || v < 0 {
+			return wizardState{}, fmt.Errorf("incorrect wizard state: %q", data)
+		}
+		values[i] = v
+	}
+	return wizardState{Days: Weekdays(values[0]), Start: values[1], Minutes: values[2], Courts: values[3], Venue: fields[4]}, nil
+}
+
+// Subscription returns the subscription built by the wizard.
+func (s wizardState) Subscription() Subscription {
+	sub := Subscription{
+		Days:    s.Days,
+		Time:    Clock{}.Add(s.Start),
+		Minutes: s.Minutes,
+	}
+	if s.Courts > 1 {
+		sub.Courts = s.Courts
+	}
+	if s.Venue != Venues.DefaultID() {
+		sub.Venue = s.Venue
+	}
+	return sub
+}
+
+// venue returns the venue picked in the wizard.
+func (s wizardState) venue() (VenueInfo, error) {
+	v, ok := Venues.Get(s.Venue)
+	if !ok {
+		return VenueInfo{}, fmt.Errorf("unknown venue: %s", s.Venue)
+	}
+	return v.Info(), nil
+}
+
+// wizardStep returns the text and the keyboard for the step after the button with the unique is pressed,
+// blank unique starts the wizard.
+func wizardStep(s wizardState, pressed string) (string, *tele.ReplyMarkup, error) {
+	venue, err := s.venue()
+	if err != nil {
+		return "", nil, err
+	}
+	var text string
+	var markup *tele.ReplyMarkup
+	switch pressed {
+	case "", wizardDay:
+		text, markup = wizardDaysStep(s)
+	case wizardDays:
+		if len(Venues) > 1 {
+			text, markup = wizardVenueStep(s)
+			break
+		}
+		text, markup = wizardTimeStep(s, venue)
+	case wizardVenue:
+		text, markup = wizardTimeStep(s, venue)
+	case wizardTime:
+		text, markup = wizardMinutesStep(s, venue)
+	case wizardMinutes:
+		text, markup = wizardCourtsStep(s, venue)
+	default:
+		text, markup = wizardConfirmStep(s)
+	}
+	return text, markup, nil
+}
+
+func wizardDaysStep(s wizardState) (string, *tele.ReplyMarkup) {
+	m := &tele.ReplyMarkup{}
+	var days []tele.Btn
+	for _, day := range weekOrder {
+		text := day.String()[:3]
+		if s.Days.Has(day) {
+			text = "✅ " + text
+		}
+		toggled := s
+		toggled.Days ^= NewWeekdays(day)
+		days = append(days, m.Data(text, wizardDay, toggled.String()))
+	}
+	rows := m.Split(4, days)
+	var groups []tele.Btn
+	for _, preset := range []Weekdays{WorkingDays, Weekend, AllDays} {
+		group := s
+		group.Days = preset
+		groups = append(groups, m.Data(preset.String(), wizardDay, group.String()))
+	}
+	rows = append(rows, m.Row(groups...))
+	controls := []tele.Btn{m.Data("Cancel", wizardCancel)}
+	if s.Days != 0 {
+		controls = append(controls, m.Data("Next", wizardDays, s.String()))
+	}
+	rows = append(rows, m.Row(controls...))
+	m.Inline(rows...)
+	text := "Pick days"
+	if s.Days != 0 {
+		text = fmt.Sprintf("Pick days: %s", s.Days)
+	}
+	return text, m
+}
+
+func wizardVenueStep(s wizardState) (string, *tele.ReplyMarkup) {
+	m := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	for _, v := range Venues {
+		next := s
+		next.Venue = v.Info().ID
+		rows = append(rows, m.Row(m.Data(v.Info().Name, wizardVenue, next.String())))
+	}
+	rows = append(rows, m.Row(m.Data("Cancel", wizardCancel)))
+	m.Inline(rows...)
+	return fmt.Sprintf("%s\nPick venue", s.Days), m
+}
+
+// wizardTimeStep offers start times of slots within opening hours of the venue in the venue timezone.
+func wizardTimeStep(s wizardState, venue VenueInfo) (string, *tele.ReplyMarkup) {
+	m := &tele.ReplyMarkup{}
+	var times []tele.Btn
+	step := int(SlotDuration / time.Minute)
+	first := (venue.Open.Minutes() + step - 1) / step * step
+	last := venue.Close.Minutes() - int(MinBookingDuration/time.Minute)
+	for start := first; start <= last; start += step {
+		next := s
+		next.Start = start
+		times = append(times, m.Data(Clock{}.Add(start).String(), wizardTime, next.String()))
+	}
+	rows := append(m.Split(4, times), m.Row(m.Data("Cancel", wizardCancel)))
+	m.Inline(rows...)
+	return fmt.Sprintf("%s\nPick start time at %s (%s time)", s.Days, venue.Name, venue.Location), m
+}
+
+// wizardMinutesStep offers durations of bookings which end before the venue closes.
+func wizardMinutesStep(s wizardState, venue VenueInfo) (string, *tele.ReplyMarkup) {
+	m := &tele.ReplyMarkup{}
+	var durations []tele.Btn
+	step := int(SlotDuration / time.Minute)
+	for minutes := int(MinBookingDuration / time.Minute); minutes <= 3*60 && s.Start+minutes <= venue.Close.Minutes(); minutes += step {
+		next := s
+		next.Minutes = minutes
+		durations = append(durations, m.Data(formatMinutes(minutes), wizardMinutes, next.String()))
+	}
+	rows := append(m.Split(5, durations), m.Row(m.Data("Cancel", wizardCancel)))
+	m.Inline(rows...)
+	return fmt.Sprintf("%s %s\nPick duration", s.Days, Clock{}.Add(s.Start)), m
+}
+
+func wizardCourtsStep(s wizardState, venue VenueInfo) (string, *tele.ReplyMarkup) {
+	m := &tele.ReplyMarkup{}
+	var courts []tele.Btn
+	for n := 1; n <= wizardMaxCourts && n <= len(venue.Courts); n++ {
+		next := s
+		next.Courts = n
+		courts = append(courts, m.Data(strconv.Itoa(n), wizardCourts, next.String()))
+	}
+	m.Inline(m.Row(courts...), m.Row(m.Data("Cancel", wizardCancel)))
+	sub := s.Subscription()
+	return fmt.Sprintf("%s\nHow many courts?", sub.String()), m
+}
+
+func wizardConfirmStep(s wizardState) (string, *tele.ReplyMarkup) {
+	m := &tele.ReplyMarkup{}
+	m.Inline(m.Row(m.Data("Cancel", wizardCancel), m.Data("Subscribe", wizardConfirm, s.String())))
+	sub := s.Subscription()
+	return fmt.Sprintf("Subscribe to %s?", sub.String()), m
+}
+
+// handleAddWizard registers callbacks of the /add wizard.
+func handleAddWizard(b *tele.Group, store *Store) {
+	next := func(pressed string) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			s, err := parseWizardState(c.Data())
+			if err != nil {
+				return err
+			}
+			if err := c.Respond(); err != nil {
+				return err
+			}
+			text, markup, err := wizardStep(s, pressed)
+			if err != nil {
+				return c.Edit(err.Error())
+			}
+			return c.Edit(text, markup)
+		}
+	}
+	for _, unique := range []string{wizardDay, wizardDays, wizardVenue, wizardTime, wizardMinutes, wizardCourts} {
+		b.Handle(&tele.Btn{Unique: unique}, next(unique))
+	}
+	b.Handle(&tele.Btn{Unique: wizardCancel}, func(c tele.Context) error {
+		if err := c.Respond(); err != nil {
+			return err
+		}
+		return c.Edit("Cancelled")
+	})
+	b.Handle(&tele.Btn{Unique: wizardConfirm}, func(c tele.Context) error {
+		s, err := parseWizardState(c.Data())
+		if err != nil {
+			return err
+		}
+		if s.Days == 0 || s.Minutes == 0 {
+			return errors.New("incomplete subscription")
+		}
+		if err := c.Respond(); err != nil {
+			return err
+		}
+		id := chatID(c)
+		sub := s.Subscription()
+		if err := store.Subscribe(id, sub.String()); err != nil {
+			return c.Edit(err.Error())
+		}
+		return c.Edit(fmt.Sprintf("You are subscribed to\n%s", store.Subscriptions(id)))
+	})
+}
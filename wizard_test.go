@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tele "gopkg.in/telebot.v3"
+)
+
+// press returns the unique and the data of the button with the text
+func press(t *testing.T, markup *tele.ReplyMarkup, text string) (string, string) {
+	for _, row := range markup.InlineKeyboard {
+		for _, btn := range row {
+			if btn.Text == text {
+				return btn.Unique, btn.Data
+			}
+		}
+	}
+	require.Failf(t, "button not found", "%q", text)
+	return "", ""
+}
+
+func TestWizard(t *testing.T) {
+	text, markup, err := wizardStep(wizardState{}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "Pick days", text)
+	for _, step := range []struct {
+		button string
+		text   string
+	}{
+		{"Mon", "Pick days: Mon"},
+		{"Wed", "Pick days: Mon,Wed"},
+		{"✅ Mon", "Pick days: Wed"},
+		{"Weekend", "Pick days: Weekend"},
+		{"Fri", "Pick days: Fri-Sun"},
+		{"Next", "Fri-Sun\nPick start time at ABA Stadium (Pacific/Auckland time)"},
+		{"18:30", "Fri-Sun 18:30\nPick duration"},
+		{"1.5h", "Fri-Sun 18:30-20:00\nHow many courts?"},
+		{"2", "Subscribe to Fri-Sun 18:30-20:00 x2?"},
+	} {
+		unique, data := press(t, markup, step.button)
+		s, err := parseWizardState(data)
+		require.NoError(t, err)
+		text, markup, err = wizardStep(s, unique)
+		require.NoError(t, err)
+		assert.Equal(t, step.text, text)
+	}
+
+	unique, data := press(t, markup, "Subscribe")
+	assert.Equal(t, wizardConfirm, unique)
+	s, err := parseWizardState(data)
+	require.NoError(t, err)
+	sub := s.Subscription()
+	parsed, err := ParseTimeRange(sub.String())
+	require.NoError(t, err)
+	assert.Equal(t, Subscription{Days: NewWeekdays(time.Friday, time.Saturday, time.Sunday), Time: Clock{Hour: 18, Minute: 30}, Minutes: 90, Courts: 2}, parsed)
+}
+
+func TestWizard_Limits(t *testing.T) {
+	_, markup, err := wizardStep(wizardState{Days: AllDays}, wizardDays)
+	require.NoError(t, err)
+	press(t, markup, "06:00")
+	press(t, markup, "21:00")
+	assert.Len(t, markup.InlineKeyboard, 9, "31 start times and cancel")
+
+	_, markup, err = wizardStep(wizardState{Days: AllDays, Start: 20 * 60}, wizardTime)
+	require.NoError(t, err)
+	press(t, markup, "2h")
+	assert.Len(t, markup.InlineKeyboard[0], 3, "bookings end before closing")
+}
+
+func TestWizard_Venue(t *testing.T) {
+	late := VenueInfo{
+		ID:       "late",
+		Name:     "Late Club",
+		Courts:   courtRange(2),
+		Open:     Clock{Hour: 0},
+		Close:    Clock{Hour: 2},
+		Location: mustLoadLocation("Europe/London"),
+	}
+	withVenues(t, VenueList{NewABAVenue(), &ABAVenue{VenueInfo: late}})
+
+	text, markup, err := wizardStep(wizardState{Days: Weekend}, wizardDays)
+	require.NoError(t, err)
+	assert.Equal(t, "Weekend\nPick venue", text)
+	press(t, markup, "ABA Stadium")
+	unique, data := press(t, markup, "Late Club")
+	s, err := parseWizardState(data)
+	require.NoError(t, err)
+
+	text, markup, err = wizardStep(s, unique)
+	require.NoError(t, err)
+	assert.Equal(t, "Weekend\nPick start time at Late Club (Europe/London time)", text)
+	assert.Len(t, markup.InlineKeyboard, 2, "3 start times and cancel")
+	unique, data = press(t, markup, "00:00")
+	s, err = parseWizardState(data)
+	require.NoError(t, err)
+
+	text, markup, err = wizardStep(s, unique)
+	require.NoError(t, err)
+	assert.Equal(t, "Weekend 00:00\nPick duration", text)
+	assert.Len(t, markup.InlineKeyboard[0], 3, "bookings end before closing")
+	unique, data = press(t, markup, "2h")
+	s, err = parseWizardState(data)
+	require.NoError(t, err)
+
+	_, markup, err = wizardStep(s, unique)
+	require.NoError(t, err)
+	assert.Len(t, markup.InlineKeyboard[0], 2, "the venue has 2 courts")
+	sub := s.Subscription()
+	assert.Equal(t, "Weekend 00:00-02:00 @late", sub.String())
+
+	_, _, err = wizardStep(wizardState{Venue: "closed"}, wizardVenue)
+	assert.Error(t, err)
+}
+
+func TestParseWizardState(t *testing.T) {
+	s := wizardState{Days: Weekend, Start: 600, Minutes: 90, Courts: 2, Venue: "aba"}
+	parsed, err := parseWizardState(s.String())
+	require.NoError(t, err)
+	assert.Equal(t, s, parsed)
+
+	for _, data := range []string{"", "1|2|3|4", "1|2|3|x|", "1|-2|3|4|"} {
+		_, err := parseWizardState(data)
+		assert.Error(t, err, data)
+	}
+}